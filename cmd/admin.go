@@ -0,0 +1,266 @@
+/*
+Copyright © 2025 Johandré van Deventer <johandre.vandeventer@rubiconsa.com>
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/johandrevandeventer/dse-worker/internal/config"
+	"github.com/johandrevandeventer/dse-worker/internal/engine"
+	"github.com/spf13/cobra"
+)
+
+var (
+	adminSocketPath  string
+	adminHTTPAddress string
+	adminOutput      string
+	adminReason      string
+	adminExpires     string
+)
+
+// adminCmd represents the admin command
+var adminCmd = &cobra.Command{
+	Use:   AdminCmdUse,
+	Short: AdminCmdShort,
+	Long:  AdminCmdLong,
+}
+
+var adminConfigCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Show the effective configuration of the running worker",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return adminPrint(http.MethodGet, "/config", nil)
+	},
+}
+
+var adminStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the runtime state of the running worker",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return adminPrint(http.MethodGet, "/status", nil)
+	},
+}
+
+var adminStateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Dump the persisted state of the running worker",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return adminPrint(http.MethodGet, "/state", nil)
+	},
+}
+
+var adminDevicesCmd = &cobra.Command{
+	Use:   "devices",
+	Short: "Show when every device was last seen",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if adminOutput == "json" {
+			return adminPrint(http.MethodGet, "/devices", nil)
+		}
+
+		if adminOutput != "table" {
+			return fmt.Errorf("unknown output format: %s", adminOutput)
+		}
+
+		data, err := adminRequest(http.MethodGet, "/devices", nil)
+		if err != nil {
+			return err
+		}
+
+		var devices []engine.DeviceActivity
+		if err := json.Unmarshal(data, &devices); err != nil {
+			return fmt.Errorf("invalid response: %w", err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "DEVICE\tCONTROLLER\tTYPE\tCUSTOMER\tSITE\tLAST SEEN\tMESSAGES")
+		for _, device := range devices {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\n", device.Device, device.Controller, device.DeviceType, device.Customer, device.Site, device.LastSeen.Format(time.RFC3339), device.Messages)
+		}
+		return w.Flush()
+	},
+}
+
+var adminIgnoredCmd = &cobra.Command{
+	Use:   "ignored",
+	Short: "List, add and remove ignored controllers",
+}
+
+var adminIgnoredListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the ignore list",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return adminPrint(http.MethodGet, "/ignored", nil)
+	},
+}
+
+var adminIgnoredAddCmd = &cobra.Command{
+	Use:   "add <controller>",
+	Short: "Ignore a controller",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		request := engine.AddIgnoredControllerRequest{ID: args[0], Reason: adminReason}
+
+		// The expiry is either a duration from now or a timestamp
+		if adminExpires != "" {
+			var expiresAt time.Time
+			if d, err := time.ParseDuration(adminExpires); err == nil {
+				expiresAt = time.Now().Add(d)
+			} else if expiresAt, err = time.Parse(time.RFC3339, adminExpires); err != nil {
+				return fmt.Errorf("invalid expiry %q, use a duration such as 24h or an RFC 3339 timestamp", adminExpires)
+			}
+			request.ExpiresAt = &expiresAt
+		}
+
+		return adminPrint(http.MethodPost, "/ignored/controllers", request)
+	},
+}
+
+var adminIgnoredRemoveCmd = &cobra.Command{
+	Use:   "remove <controller>",
+	Short: "Stop ignoring a controller",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return adminPrint(http.MethodDelete, "/ignored/controllers/"+url.PathEscape(args[0]), nil)
+	},
+}
+
+var adminPauseCmd = &cobra.Command{
+	Use:   "pause",
+	Short: "Pause consuming messages",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return adminPrint(http.MethodPost, "/pause", nil)
+	},
+}
+
+var adminResumeCmd = &cobra.Command{
+	Use:   "resume",
+	Short: "Resume consuming messages",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return adminPrint(http.MethodPost, "/resume", nil)
+	},
+}
+
+var adminFlushCacheCmd = &cobra.Command{
+	Use:   "flush-cache",
+	Short: "Flush the device cache",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return adminPrint(http.MethodPost, "/cache/flush", nil)
+	},
+}
+
+// adminRequest sends a request to the admin API of the running worker and returns the response body
+func adminRequest(method string, path string, body any) ([]byte, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	baseURL := "http://" + adminHTTPAddress
+
+	// Without an HTTP address the admin socket is used
+	if adminHTTPAddress == "" {
+		socketPath := adminSocketPath
+		if socketPath == "" {
+			cfg, err := config.GetConfig()
+			if err != nil {
+				return nil, err
+			}
+			socketPath = cfg.App.Admin.SocketPath
+		}
+
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socketPath)
+			},
+		}
+		baseURL = "http://admin"
+	}
+
+	var requestBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		requestBody = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequest(method, baseURL+path, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("worker does not appear to be running: %w", err)
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if response.StatusCode >= http.StatusBadRequest {
+		var adminErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &adminErr) == nil && adminErr.Error != "" {
+			return nil, fmt.Errorf("%s", adminErr.Error)
+		}
+		return nil, fmt.Errorf("request failed: %s", response.Status)
+	}
+
+	return data, nil
+}
+
+// adminPrint sends a request to the admin API of the running worker and prints the response
+func adminPrint(method string, path string, body any) error {
+	data, err := adminRequest(method, path, body)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "  "); err != nil {
+		out.Reset()
+		out.Write(data)
+	}
+
+	fmt.Println(string(bytes.TrimSpace(out.Bytes())))
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(adminCmd)
+
+	adminCmd.PersistentFlags().StringVar(&adminSocketPath, "socket", "", "Admin socket of the running worker (default from app.yaml)")
+	adminCmd.PersistentFlags().StringVar(&adminHTTPAddress, "address", "", "Localhost HTTP address of the admin API, instead of the socket")
+
+	adminDevicesCmd.Flags().StringVarP(&adminOutput, "output", "o", "table", "Output format: table or json")
+
+	adminIgnoredAddCmd.Flags().StringVar(&adminReason, "reason", "", "Why the controller is ignored")
+	adminIgnoredAddCmd.Flags().StringVar(&adminExpires, "expires", "", "When the rule stops applying, as a duration (e.g. 24h) or an RFC 3339 timestamp")
+
+	adminIgnoredCmd.AddCommand(adminIgnoredListCmd, adminIgnoredAddCmd, adminIgnoredRemoveCmd)
+	adminCmd.AddCommand(adminConfigCmd, adminStatusCmd, adminStateCmd, adminDevicesCmd, adminIgnoredCmd, adminPauseCmd, adminResumeCmd, adminFlushCacheCmd)
+}
@@ -18,3 +18,46 @@ const (
 	VersionCmdShort = "Print the version number of bms-mqtt-worker-pi"
 	VersionCmdLong  = `All software has versions. This is bms-mqtt-worker-pi's`
 )
+
+// ==================== Flush Cache Command ====================
+const (
+	FlushCacheCmdUse   = "flush-cache"
+	FlushCacheCmdShort = "Flush the device cache of a running worker"
+	FlushCacheCmdLong  = `Signals a running worker to drop its cached devices and customers,
+so that changes in the devices database are picked up immediately.`
+)
+
+// ==================== Decode Command ====================
+const (
+	DecodeCmdUse   = "decode [file]"
+	DecodeCmdShort = "Decode a DSE payload offline"
+	DecodeCmdLong  = `Decodes a DSE payload read from a file or stdin, without Kafka or the devices database.
+The payload is either a serialized Kafka payload or the controller JSON itself.
+Prints the raw and processed value of every field, with its register, ratio and sentinel handling.
+
+Example:
+  dse-worker decode --model DSE890 --type genset < payload.json`
+)
+
+// ==================== Replay Command ====================
+const (
+	ReplayCmdUse   = "replay <file.jsonl>"
+	ReplayCmdShort = "Replay captured messages through the worker"
+	ReplayCmdLong  = `Reads serialized payloads from a JSON lines file, one per line, and runs them through the worker
+like messages consumed from Kafka. The resulting device records are routed by the routing table and
+written to the chosen output sink, stdout by default. A summary by error category is printed at the end.
+
+The devices database and the ignore list are used as by a running worker.`
+)
+
+// ==================== Admin Command ====================
+const (
+	AdminCmdUse   = "admin"
+	AdminCmdShort = "Manage a running worker through its admin API"
+	AdminCmdLong  = `Talks to the admin API of a running worker, over its Unix socket or localhost HTTP address.
+Shows the effective configuration, runtime and persisted state and when devices were last seen,
+manages ignored controllers, pauses and resumes consumption and flushes the device cache.
+
+Example:
+  dse-worker admin ignored add 1912AC8630C56A0 --reason "commissioning" --expires 72h`
+)
@@ -0,0 +1,106 @@
+/*
+Copyright © 2025 Johandré van Deventer <johandre.vandeventer@rubiconsa.com>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	dseworker "github.com/johandrevandeventer/dse-worker/internal/workers/dse_worker"
+	"github.com/johandrevandeventer/dse-worker/internal/workers/types"
+	"github.com/spf13/cobra"
+)
+
+var (
+	decodeModel      string
+	decodeDeviceType string
+	decodeOutput     string
+)
+
+// decodeCmd represents the decode command
+var decodeCmd = &cobra.Command{
+	Use:   DecodeCmdUse,
+	Short: DecodeCmdShort,
+	Long:  DecodeCmdLong,
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var input io.Reader = os.Stdin
+		if len(args) == 1 {
+			file, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			input = file
+		}
+
+		msg, err := io.ReadAll(input)
+		if err != nil {
+			return fmt.Errorf("failed to read payload: %w", err)
+		}
+
+		controllers, err := dseworker.DecodeOffline(decodeModel, decodeDeviceType, msg)
+		if err != nil {
+			return err
+		}
+
+		switch decodeOutput {
+		case "json":
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(controllers)
+		case "table":
+			printDecodedControllers(controllers)
+			return nil
+		}
+
+		return fmt.Errorf("unknown output format: %s", decodeOutput)
+	},
+}
+
+// printDecodedControllers prints the fields of every decoded controller as a table
+func printDecodedControllers(controllers []types.DecodedController) {
+	for i, controller := range controllers {
+		if i > 0 {
+			fmt.Println()
+		}
+
+		fmt.Printf("Controller %s (%s)\n\n", controller.Controller, controller.DeviceType)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "FIELD\tREGISTER\tRATIO\tRAW\tPROCESSED\tNOTE")
+		for _, field := range controller.Fields {
+			note := ""
+			switch {
+			case field.Missing:
+				note = "missing, read as 0"
+			case field.Sentinel:
+				note = "no-reading sentinel, processed as 0"
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				field.Name,
+				field.Register,
+				strconv.FormatFloat(field.Ratio, 'g', -1, 64),
+				strconv.FormatFloat(field.Raw, 'f', -1, 64),
+				strconv.FormatFloat(field.Processed, 'f', -1, 64),
+				note,
+			)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%v\t%v\t%s\n", "SerialNo1", "-", "-", controller.RawData["SerialNo1"], controller.ProcessedData["SerialNo1"], "payload key")
+		w.Flush()
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(decodeCmd)
+
+	decodeCmd.Flags().StringVar(&decodeModel, "model", "DSE890", fmt.Sprintf("Controller model of the payload %v", dseworker.Models()))
+	decodeCmd.Flags().StringVar(&decodeDeviceType, "type", "genset", "Device type of the controllers")
+	decodeCmd.Flags().StringVarP(&decodeOutput, "output", "o", "table", "Output format (table or json)")
+}
@@ -0,0 +1,40 @@
+/*
+Copyright © 2025 Johandré van Deventer <johandre.vandeventer@rubiconsa.com>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/johandrevandeventer/dse-worker/internal/config"
+	"github.com/spf13/cobra"
+)
+
+// flushCacheCmd represents the flush-cache command
+var flushCacheCmd = &cobra.Command{
+	Use:   FlushCacheCmdUse,
+	Short: FlushCacheCmdShort,
+	Long:  FlushCacheCmdLong,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.GetConfig()
+		if err != nil {
+			return err
+		}
+
+		if _, err := os.Stat(cfg.App.Runtime.TmpDir); err != nil {
+			return fmt.Errorf("worker does not appear to be running: %w", err)
+		}
+
+		if err := os.WriteFile(cfg.App.Runtime.CacheFlushFilePath, nil, 0o644); err != nil {
+			return fmt.Errorf("failed to request cache flush: %w", err)
+		}
+
+		fmt.Println("Cache flush requested")
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(flushCacheCmd)
+}
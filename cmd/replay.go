@@ -0,0 +1,248 @@
+/*
+Copyright © 2025 Johandré van Deventer <johandre.vandeventer@rubiconsa.com>
+*/
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/johandrevandeventer/dse-worker/initializers"
+	"github.com/johandrevandeventer/dse-worker/internal/config"
+	"github.com/johandrevandeventer/dse-worker/internal/config/app"
+	"github.com/johandrevandeventer/dse-worker/internal/engine"
+	"github.com/johandrevandeventer/dse-worker/internal/flags"
+	"github.com/johandrevandeventer/dse-worker/internal/workers"
+	dseworker "github.com/johandrevandeventer/dse-worker/internal/workers/dse_worker"
+	"github.com/johandrevandeventer/dse-worker/internal/workers/types"
+	"github.com/johandrevandeventer/kafkaclient/payload"
+	"github.com/johandrevandeventer/logging"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// maxReplayLineSize bounds the size of a line of a replay file
+const maxReplayLineSize = 16 * 1024 * 1024
+
+var (
+	replaySink   string
+	replayRate   float64
+	replayDryRun bool
+)
+
+// replaySummary counts the outcome of replayed messages
+type replaySummary struct {
+	messages  int
+	succeeded int
+	records   int
+	failed    map[string]int // By error category
+}
+
+// replayCmd represents the replay command
+var replayCmd = &cobra.Command{
+	Use:   ReplayCmdUse,
+	Short: ReplayCmdShort,
+	Long:  ReplayCmdLong,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		cfg, err := initReplay()
+		if err != nil {
+			return err
+		}
+
+		// Stop replaying on Ctrl+C, still printing the summary
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		var sink engine.OutputSink
+		if !replayDryRun {
+			sink, err = newReplaySink(ctx, cfg)
+			if err != nil {
+				return err
+			}
+			defer sink.Close()
+		}
+
+		var workersLogger *zap.Logger
+		if flags.FlagWorkersLogging || cfg.App.Features.WorkersLogging {
+			workersLogger = logging.GetLogger("workers")
+		} else {
+			workersLogger = zap.NewNop()
+		}
+		worker := dseworker.NewWorker(workersLogger)
+
+		// Pace the messages when a rate is set
+		var ticker *time.Ticker
+		if replayRate > 0 {
+			ticker = time.NewTicker(time.Duration(float64(time.Second) / replayRate))
+			defer ticker.Stop()
+		}
+
+		summary := &replaySummary{failed: make(map[string]int)}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxReplayLineSize)
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if len(line) == 0 {
+				continue
+			}
+
+			if ticker != nil {
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+				}
+			}
+
+			if ctx.Err() != nil {
+				break
+			}
+
+			summary.messages++
+			records, err := replayMessage(ctx, worker, cfg, sink, line)
+			if err != nil {
+				category := workers.ErrorCategory(err)
+				summary.failed[category]++
+				fmt.Fprintf(os.Stderr, "line %d: %s: %v\n", summary.messages, category, err)
+				continue
+			}
+
+			summary.succeeded++
+			summary.records += records
+		}
+
+		summary.print()
+
+		return scanner.Err()
+	},
+}
+
+// initReplay loads the environment, the configuration, the ignore list and the device cache settings
+func initReplay() (*config.Config, error) {
+	// A missing .env file is not an error when the database is configured in app.yaml
+	_ = initializers.LoadEnvVariable()
+
+	if err := initializers.InitConfig(); err != nil {
+		return nil, err
+	}
+
+	cfg, err := config.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	initializers.InitLogger(cfg)
+
+	if err := initializers.InitDatabase(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := workers.LoadIgnoredFile(cfg.App.Ignored.FilePath); err != nil {
+		return nil, fmt.Errorf("failed to load ignore list: %w", err)
+	}
+
+	workers.ConfigureCache(
+		time.Duration(cfg.App.Cache.TTLSeconds)*time.Second,
+		time.Duration(cfg.App.Cache.NegativeTTLSeconds)*time.Second,
+	)
+
+	return cfg, nil
+}
+
+// newReplaySink creates the output sink the replayed records are written to
+func newReplaySink(ctx context.Context, cfg *config.Config) (engine.OutputSink, error) {
+	if replaySink == app.SinkTypeStdout {
+		return engine.NewOutputSink(ctx, app.SinkConfig{Name: replaySink, Type: app.SinkTypeStdout}, cfg.App.Kafka, zap.NewNop())
+	}
+
+	idx := slices.IndexFunc(cfg.App.Sinks, func(s app.SinkConfig) bool {
+		return s.Name == replaySink
+	})
+	if idx < 0 {
+		return nil, fmt.Errorf("unknown sink: %s", replaySink)
+	}
+
+	return engine.NewOutputSink(ctx, cfg.App.Sinks[idx], cfg.App.Kafka, logging.GetLogger("kafka.producer"))
+}
+
+// replayMessage runs a serialized payload through the worker and writes the routed records to the sink.
+// It returns the number of records, which are counted but not written on a dry run.
+func replayMessage(ctx context.Context, worker *dseworker.Worker, cfg *config.Config, sink engine.OutputSink, msg []byte) (int, error) {
+	p, err := payload.Deserialize(msg)
+	if err != nil {
+		return 0, &workers.WorkerError{Worker: dseworker.WorkerTitle, Category: workers.CategoryDeserialize, Err: err}
+	}
+
+	messageInfo, err := worker.RunWorker(*p)
+	if err != nil {
+		return 0, err
+	}
+
+	var records []engine.Record
+	for _, device := range messageInfo.Devices {
+		for _, state := range []string{types.StatePre, types.StatePost} {
+			dataStruct := device.DataStruct(state)
+
+			serializedPayload, err := engine.SerializeDataStruct(p.ID, dataStruct)
+			if err != nil {
+				return 0, &workers.WorkerError{Worker: dseworker.WorkerTitle, Decoder: messageInfo.Decoder, Category: workers.CategoryProcess, Err: err}
+			}
+
+			for _, topic := range engine.RouteTopics(cfg.App.Routes, dataStruct) {
+				records = append(records, engine.Record{Sink: replaySink, Topic: topic, Value: serializedPayload})
+			}
+		}
+	}
+
+	if sink == nil || len(records) == 0 {
+		return len(records), nil
+	}
+
+	if err := sink.Publish(ctx, records); err != nil {
+		return 0, &workers.WorkerError{Worker: dseworker.WorkerTitle, Decoder: messageInfo.Decoder, Category: workers.CategoryPublish, Err: err}
+	}
+
+	return len(records), nil
+}
+
+// print writes the summary to stderr, keeping stdout for the records
+func (s *replaySummary) print() {
+	failed := s.messages - s.succeeded
+
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintf(os.Stderr, "Messages:  %d\n", s.messages)
+	fmt.Fprintf(os.Stderr, "Succeeded: %d\n", s.succeeded)
+	fmt.Fprintf(os.Stderr, "Records:   %d\n", s.records)
+	fmt.Fprintf(os.Stderr, "Failed:    %d\n", failed)
+
+	categories := make([]string, 0, len(s.failed))
+	for category := range s.failed {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+
+	for _, category := range categories {
+		fmt.Fprintf(os.Stderr, "  %-20s %d\n", category, s.failed[category])
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+
+	replayCmd.Flags().StringVar(&replaySink, "sink", app.SinkTypeStdout, "Output sink of the records, stdout or the name of a sink configured in app.yaml")
+	replayCmd.Flags().Float64Var(&replayRate, "rate", 0, "Maximum number of messages replayed per second, 0 for no limit")
+	replayCmd.Flags().BoolVar(&replayDryRun, "dry-run", false, "Run the worker without writing records")
+}
@@ -20,9 +20,6 @@ var rootCmd = &cobra.Command{
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
 		if cmd.CalledAs() == RootCmdUse {
 			config.PrintInfo(false)
-		} else {
-			config.PrintInfo(true)
-			os.Exit(0)
 		}
 	},
 	Run: func(cmd *cobra.Command, args []string) {
@@ -31,11 +28,16 @@ var rootCmd = &cobra.Command{
 }
 
 func Execute() {
-	err := rootCmd.Execute()
+	executedCmd, err := rootCmd.ExecuteC()
 	if err != nil {
 		os.Exit(1)
 	}
 
+	// Only the root command starts the engine, exit once a subcommand has run
+	if executedCmd != rootCmd {
+		os.Exit(0)
+	}
+
 	// Exit if the help flag is set
 	helpFlag, _ := rootCmd.Flags().GetBool("help")
 	if helpFlag {
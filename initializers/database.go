@@ -0,0 +1,21 @@
+package initializers
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/johandrevandeventer/dse-worker/internal/config"
+)
+
+// InitDatabase exports the configured database URL for the devices database client
+func InitDatabase(cfg *config.Config) error {
+	if cfg.App.Database.URL == "" {
+		return nil
+	}
+
+	if err := os.Setenv("DB_URL", cfg.App.Database.URL); err != nil {
+		return fmt.Errorf("error setting database URL: %w", err)
+	}
+
+	return nil
+}
@@ -1,25 +1,56 @@
 package app
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 
 	coreutils "github.com/johandrevandeventer/dse-worker/utils"
+	"gopkg.in/yaml.v2"
 )
 
 var (
 	appConfig *AppConfig
 
 	// Default configurations
-	defaultAppConfig     *AppConfig
-	defaultRuntimeConfig *RuntimeConfig
-	defaultLoggingConfig *LoggingConfig
+	defaultAppConfig          *AppConfig
+	defaultRuntimeConfig      *RuntimeConfig
+	defaultLoggingConfig      *LoggingConfig
+	defaultKafkaConfig        *KafkaConfig
+	defaultDeadLetterConfig   *DeadLetterConfig
+	defaultErrorPolicies      map[string]ErrorPolicyConfig
+	defaultWorkersConfig      *WorkersConfig
+	defaultCacheConfig        *CacheConfig
+	defaultIgnoredConfig      *IgnoredConfig
+	defaultMonitoringConfig   *MonitoringConfig
+	defaultAdminConfig        *AdminConfig
+	defaultStatsConfig        *StatsConfig
+	defaultConnectivityConfig *ConnectivityConfig
+	defaultAlarmsConfig       *AlarmsConfig
+	defaultRunSessionsConfig  *RunSessionsConfig
+	defaultDedupConfig        *DedupConfig
+	defaultSinks              []SinkConfig
+	defaultRoutes             []RouteConfig
+
+	// Default overlays per environment profile
+	defaultProfileOverlays map[string]map[string]any
 
 	// File paths
 	persistFilePath        = filepath.Join(coreutils.GetPersistDir(), "persist.json")
 	loggingFilePath        = filepath.Join(coreutils.GetLoggingDir(), "app.jsonl")
 	stopFileFilePath       = filepath.Join(coreutils.GetTmpDir(), "stop_signal")
 	connectionsLogFilePath = filepath.Join(coreutils.GetConnectionsDir(), "connections.log")
+	cacheFlushFilePath     = filepath.Join(coreutils.GetTmpDir(), "flush_cache")
+	pauseFilePath          = filepath.Join(coreutils.GetTmpDir(), "pause")
+	resumeFilePath         = filepath.Join(coreutils.GetTmpDir(), "resume")
+	reloadConfigFilePath   = filepath.Join(coreutils.GetTmpDir(), "reload-config")
+	reloadIgnoredFilePath  = filepath.Join(coreutils.GetTmpDir(), "reload-ignored")
+	dumpStateFilePath      = filepath.Join(coreutils.GetTmpDir(), "dump-state")
+	stateDumpFilePath      = filepath.Join(coreutils.GetPersistDir(), "state_dump.json")
+	adminSocketPath        = filepath.Join(coreutils.GetRuntimeDir(), "admin.sock")
+	ignoredFilePath        = filepath.Join(coreutils.GetConfigDir(), "ignored.json")
+	dedupFilePath          = filepath.Join(coreutils.GetPersistDir(), "dedup.json")
 )
 
 func init() {
@@ -34,6 +65,13 @@ func init() {
 		PersistFilePath:        persistFilePath,
 		StopFileFilepath:       stopFileFilePath,
 		ConnectionsLogFilePath: connectionsLogFilePath,
+		CacheFlushFilePath:     cacheFlushFilePath,
+		PauseFilePath:          pauseFilePath,
+		ResumeFilePath:         resumeFilePath,
+		ReloadConfigFilePath:   reloadConfigFilePath,
+		ReloadIgnoredFilePath:  reloadIgnoredFilePath,
+		DumpStateFilePath:      dumpStateFilePath,
+		StateDumpFilePath:      stateDumpFilePath,
 	}
 
 	defaultLoggingConfig = &LoggingConfig{
@@ -46,9 +84,198 @@ func init() {
 		AddTime:    true,
 	}
 
+	defaultKafkaConfig = &KafkaConfig{
+		Brokers:                   []string{"localhost:9092"},
+		ConsumerGroup:             "dse-consumer-group",
+		InputTopics:               []string{"rubicon_kafka_dse"},
+		ClientOptions:             map[string]string{},
+		SessionTimeoutMs:          45000,
+		SocketTimeoutMs:           60000,
+		MessageTimeoutMs:          300000,
+		ConsumerOptions:           map[string]string{},
+		ProducerPoolSize:          5,
+		ProducerMaxRetries:        5,
+		ProducerRetryBackoffMs:    500,
+		ProducerRetryMaxBackoffMs: 30000,
+		ProducerOptions:           map[string]string{},
+		SendTimeoutMs:             10000,
+		ConnectTimeoutMs:          10000,
+		ReconnectBackoffMs:        1000,
+		ReconnectMaxBackoffMs:     60000,
+		PublishMode:               PublishModeDefault,
+	}
+
+	defaultDeadLetterConfig = &DeadLetterConfig{
+		Enabled: true,
+		Topic:   "rubicon_kafka_dse_dead_letter",
+	}
+
+	defaultErrorPolicies = map[string]ErrorPolicyConfig{
+		"controller_ignored": {LogLevel: "warn", Action: ErrorActionDrop},
+		"device_ignored":     {LogLevel: "warn", Action: ErrorActionDrop},
+		"device_not_found":   {LogLevel: "warn", Action: ErrorActionDeadLetter},
+		"customer_unknown":   {LogLevel: "warn", Action: ErrorActionDeadLetter},
+		"customer":           {LogLevel: "error", Action: ErrorActionRetry, MaxRetries: 3, RetryBackoffMs: 1000},
+		"deserialize":        {LogLevel: "error", Action: ErrorActionDeadLetter},
+		"decode":             {LogLevel: "error", Action: ErrorActionDeadLetter},
+		"process":            {LogLevel: "error", Action: ErrorActionDeadLetter},
+		"publish":            {LogLevel: "error", Action: ErrorActionDeadLetter},
+	}
+
+	defaultWorkersConfig = &WorkersConfig{
+		PoolSize:            runtime.NumCPU(),
+		QueueDepth:          100,
+		DrainTimeoutSeconds: 30,
+	}
+
+	defaultCacheConfig = &CacheConfig{
+		TTLSeconds:         300,
+		NegativeTTLSeconds: 60,
+	}
+
+	defaultIgnoredConfig = &IgnoredConfig{
+		FilePath:              ignoredFilePath,
+		ReloadIntervalSeconds: 5,
+	}
+
+	defaultMonitoringConfig = &MonitoringConfig{
+		Enabled:                true,
+		Address:                ":2112",
+		LivenessTimeoutSeconds: 60,
+		PublishMaxAgeSeconds:   300,
+	}
+
+	defaultAdminConfig = &AdminConfig{
+		Enabled:     true,
+		SocketPath:  adminSocketPath,
+		HTTPAddress: "",
+	}
+
+	defaultStatsConfig = &StatsConfig{
+		FlushIntervalSeconds: 30,
+	}
+
+	defaultConnectivityConfig = &ConnectivityConfig{
+		Enabled:              true,
+		Sink:                 "kafka",
+		Topic:                "rubicon_kafka_dse_events",
+		OfflineAfterSeconds:  900,
+		CheckIntervalSeconds: 30,
+		Thresholds:           []OfflineThresholdConfig{},
+	}
+
+	// AutoMode is an operating mode rather than an alarm, and is not tracked by default
+	defaultAlarmsConfig = &AlarmsConfig{
+		Enabled: true,
+		Sink:    "kafka",
+		Topic:   "rubicon_kafka_dse_alarms",
+		Alarms: []AlarmConfig{
+			{Status: "Estop", Severity: "critical"},
+			{Status: "FailStart", Severity: "critical"},
+			{Status: "MainFail", Severity: "warning"},
+			{Status: "ComAlarm", Severity: "warning"},
+			{Status: "Maintanance", Severity: "info"},
+		},
+	}
+
+	defaultRunSessionsConfig = &RunSessionsConfig{
+		Enabled:              true,
+		Sink:                 "kafka",
+		Topic:                "rubicon_kafka_dse_run_sessions",
+		MinRpm:               300,
+		MinPowerKw:           1,
+		MaxGapSeconds:        900,
+		CheckIntervalSeconds: 60,
+	}
+
+	defaultDedupConfig = &DedupConfig{
+		Enabled:              true,
+		ByDeviceTimestamp:    false,
+		MaxEntries:           100000,
+		TTLSeconds:           3600,
+		FilePath:             dedupFilePath,
+		FlushIntervalSeconds: 30,
+	}
+
+	defaultSinks = []SinkConfig{
+		{
+			Name: "kafka",
+			Type: SinkTypeKafka,
+		},
+	}
+
+	defaultRoutes = []RouteConfig{
+		{
+			Name:    "influxdb",
+			Enabled: true,
+			Sink:    "kafka",
+			Topics:  []string{"rubicon_kafka_influxdb"},
+			States:  []string{"Pre", "Post"},
+		},
+		{
+			Name:    "kodelabs",
+			Enabled: true,
+			Sink:    "kafka",
+			Topics:  []string{"rubicon_kafka_kodelabs"},
+			States:  []string{"Post"},
+		},
+	}
+
+	defaultProfileOverlays = map[string]map[string]any{
+		"development": {
+			"kafka": map[string]any{
+				"consumer_group": "dse-development-consumer-group",
+				"input_topics":   []string{"rubicon_kafka_dse_development"},
+			},
+			"dead_letter": map[string]any{
+				"topic": "rubicon_kafka_dse_dead_letter_development",
+			},
+			"connectivity": map[string]any{
+				"topic": "rubicon_kafka_dse_events_development",
+			},
+			"alarms": map[string]any{
+				"topic": "rubicon_kafka_dse_alarms_development",
+			},
+			"run_sessions": map[string]any{
+				"topic": "rubicon_kafka_dse_run_sessions_development",
+			},
+			"routes": []map[string]any{
+				{
+					"name":    "influxdb",
+					"enabled": true,
+					"sink":    "kafka",
+					"topics":  []string{"rubicon_kafka_influxdb_development"},
+					"states":  []string{"Pre", "Post"},
+				},
+				{
+					"name":    "kodelabs",
+					"enabled": true,
+					"sink":    "kafka",
+					"topics":  []string{"rubicon_kafka_kodelabs_development"},
+					"states":  []string{"Post"},
+				},
+			},
+		},
+	}
+
 	defaultAppConfig = &AppConfig{
-		Runtime: *defaultRuntimeConfig,
-		Logging: *defaultLoggingConfig,
+		Runtime:       *defaultRuntimeConfig,
+		Logging:       *defaultLoggingConfig,
+		Kafka:         *defaultKafkaConfig,
+		DeadLetter:    *defaultDeadLetterConfig,
+		ErrorPolicies: defaultErrorPolicies,
+		Workers:       *defaultWorkersConfig,
+		Cache:         *defaultCacheConfig,
+		Ignored:       *defaultIgnoredConfig,
+		Monitoring:    *defaultMonitoringConfig,
+		Admin:         *defaultAdminConfig,
+		Stats:         *defaultStatsConfig,
+		Connectivity:  *defaultConnectivityConfig,
+		Alarms:        *defaultAlarmsConfig,
+		RunSessions:   *defaultRunSessionsConfig,
+		Dedup:         *defaultDedupConfig,
+		Sinks:         defaultSinks,
+		Routes:        defaultRoutes,
 	}
 
 	appConfig = defaultAppConfig
@@ -74,13 +301,70 @@ func InitAppConfig(filePath string) (fileExists bool, err error) {
 	return false, nil
 }
 
-// GetAppConfig returns the app configuration
-func GetAppConfig(filePath string) *AppConfig {
-	err := coreutils.LoadYAMLFile(filePath, &appConfig)
+// InitAppProfile initializes the overlay file of an environment profile.
+// A default overlay is only written for profiles that have one, and an existing overlay is validated.
+func InitAppProfile(filePath string, environment string) (fileExists bool, err error) {
+	if coreutils.FileExists(filePath) {
+		var profileConfig AppConfig
+		if err := coreutils.LoadYAMLFile(filePath, &profileConfig); err != nil {
+			return true, fmt.Errorf("invalid profile %s: %w", environment, err)
+		}
+		return true, nil
+	}
+
+	overlay, ok := defaultProfileOverlays[environment]
+	if !ok {
+		return false, nil
+	}
+
+	err = coreutils.SaveYAMLFile(filePath, overlay, true)
 	if err != nil {
-		appConfig = defaultAppConfig
+		return false, err
 	}
-	return appConfig
+
+	return false, nil
+}
+
+// GetAppConfig returns the app configuration with the profile overlay merged over it.
+// An invalid overlay is an error, rather than running on the base configuration of another environment.
+func GetAppConfig(filePath string, profileFilePath string) (*AppConfig, error) {
+	cfg, err := LoadAppConfig(filePath, profileFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	appConfig = cfg
+	return appConfig, nil
+}
+
+// LoadAppConfig reads the app configuration and profile overlay over a copy of the defaults, leaving the current
+// configuration untouched. Without a configuration file the defaults apply.
+func LoadAppConfig(filePath string, profileFilePath string) (*AppConfig, error) {
+	// Copy the defaults, so that decoding does not modify their maps
+	data, err := yaml.Marshal(defaultAppConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy the default configuration: %w", err)
+	}
+
+	cfg := &AppConfig{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to copy the default configuration: %w", err)
+	}
+
+	if coreutils.FileExists(filePath) {
+		if err := coreutils.LoadYAMLFile(filePath, cfg); err != nil {
+			return nil, fmt.Errorf("invalid configuration: %w", err)
+		}
+	}
+
+	// Fields set in the overlay replace those of the base configuration
+	if coreutils.FileExists(profileFilePath) {
+		if err := coreutils.LoadYAMLFile(profileFilePath, cfg); err != nil {
+			return nil, fmt.Errorf("invalid profile: %w", err)
+		}
+	}
+
+	return cfg, nil
 }
 
 // SaveAppConfig saves the app configuration
@@ -3,8 +3,25 @@ package app
 // ======================== App ======================== //
 
 type AppConfig struct {
-	Runtime RuntimeConfig `mapstructure:"runtime" yaml:"runtime"`
-	Logging LoggingConfig `mapstructure:"logging" yaml:"logging"`
+	Runtime       RuntimeConfig                `mapstructure:"runtime" yaml:"runtime"`
+	Logging       LoggingConfig                `mapstructure:"logging" yaml:"logging"`
+	Kafka         KafkaConfig                  `mapstructure:"kafka" yaml:"kafka"`
+	Database      DatabaseConfig               `mapstructure:"database" yaml:"database"`
+	Features      FeaturesConfig               `mapstructure:"features" yaml:"features"`
+	DeadLetter    DeadLetterConfig             `mapstructure:"dead_letter" yaml:"dead_letter"`
+	ErrorPolicies map[string]ErrorPolicyConfig `mapstructure:"error_policies" yaml:"error_policies"`
+	Workers       WorkersConfig                `mapstructure:"workers" yaml:"workers"`
+	Cache         CacheConfig                  `mapstructure:"cache" yaml:"cache"`
+	Ignored       IgnoredConfig                `mapstructure:"ignored" yaml:"ignored"`
+	Monitoring    MonitoringConfig             `mapstructure:"monitoring" yaml:"monitoring"`
+	Admin         AdminConfig                  `mapstructure:"admin" yaml:"admin"`
+	Stats         StatsConfig                  `mapstructure:"stats" yaml:"stats"`
+	Connectivity  ConnectivityConfig           `mapstructure:"connectivity" yaml:"connectivity"`
+	Alarms        AlarmsConfig                 `mapstructure:"alarms" yaml:"alarms"`
+	RunSessions   RunSessionsConfig            `mapstructure:"run_sessions" yaml:"run_sessions"`
+	Dedup         DedupConfig                  `mapstructure:"dedup" yaml:"dedup"`
+	Sinks         []SinkConfig                 `mapstructure:"sinks" yaml:"sinks"`
+	Routes        []RouteConfig                `mapstructure:"routes" yaml:"routes"`
 }
 
 type RuntimeConfig struct {
@@ -13,6 +30,13 @@ type RuntimeConfig struct {
 	PersistFilePath        string `mapstructure:"persist_file_path" yaml:"persist_file_path"`
 	StopFileFilepath       string `mapstructure:"stop_file_filepath" yaml:"stop_file_filepath"`
 	ConnectionsLogFilePath string `mapstructure:"connections_log_file_path" yaml:"connections_log_file_path"`
+	CacheFlushFilePath     string `mapstructure:"cache_flush_file_path" yaml:"cache_flush_file_path"`
+	PauseFilePath          string `mapstructure:"pause_file_path" yaml:"pause_file_path"`
+	ResumeFilePath         string `mapstructure:"resume_file_path" yaml:"resume_file_path"`
+	ReloadConfigFilePath   string `mapstructure:"reload_config_file_path" yaml:"reload_config_file_path"`
+	ReloadIgnoredFilePath  string `mapstructure:"reload_ignored_file_path" yaml:"reload_ignored_file_path"`
+	DumpStateFilePath      string `mapstructure:"dump_state_file_path" yaml:"dump_state_file_path"`
+	StateDumpFilePath      string `mapstructure:"state_dump_file_path" yaml:"state_dump_file_path"` // Where dump-state writes the state of the engine
 }
 
 type LoggingConfig struct {
@@ -24,3 +48,169 @@ type LoggingConfig struct {
 	Compress   bool   `mapstructure:"compress" yaml:"compress"`
 	AddTime    bool   `mapstructure:"add_time" yaml:"add_time"`
 }
+
+type KafkaConfig struct {
+	Brokers                   []string          `mapstructure:"brokers" yaml:"brokers"`
+	ConsumerGroup             string            `mapstructure:"consumer_group" yaml:"consumer_group"`
+	InputTopics               []string          `mapstructure:"input_topics" yaml:"input_topics"`
+	ClientOptions             map[string]string `mapstructure:"client_options" yaml:"client_options"`         // librdkafka settings of every Kafka client, e.g. security.protocol and sasl.*
+	SessionTimeoutMs          int               `mapstructure:"session_timeout_ms" yaml:"session_timeout_ms"` // Consumer group session timeout
+	SocketTimeoutMs           int               `mapstructure:"socket_timeout_ms" yaml:"socket_timeout_ms"`   // Timeout of network requests to the brokers
+	MessageTimeoutMs          int               `mapstructure:"message_timeout_ms" yaml:"message_timeout_ms"` // Time the producer tries to deliver a record
+	ConsumerOptions           map[string]string `mapstructure:"consumer_options" yaml:"consumer_options"`     // Extra librdkafka consumer settings
+	ProducerPoolSize          int               `mapstructure:"producer_pool_size" yaml:"producer_pool_size"`
+	ProducerMaxRetries        int               `mapstructure:"producer_max_retries" yaml:"producer_max_retries"`                   // Publish retries before a message is dead-lettered
+	ProducerRetryBackoffMs    int               `mapstructure:"producer_retry_backoff_ms" yaml:"producer_retry_backoff_ms"`         // Initial backoff between publish retries
+	ProducerRetryMaxBackoffMs int               `mapstructure:"producer_retry_max_backoff_ms" yaml:"producer_retry_max_backoff_ms"` // Upper bound of the publish retry backoff
+	ProducerOptions           map[string]string `mapstructure:"producer_options" yaml:"producer_options"`                           // Extra librdkafka producer settings
+	SendTimeoutMs             int               `mapstructure:"send_timeout_ms" yaml:"send_timeout_ms"`                             // Time to wait for the delivery of a single publish attempt
+	ConnectTimeoutMs          int               `mapstructure:"connect_timeout_ms" yaml:"connect_timeout_ms"`                       // Time a Kafka client waits for the brokers when it connects
+	ReconnectBackoffMs        int               `mapstructure:"reconnect_backoff_ms" yaml:"reconnect_backoff_ms"`                   // Initial backoff between attempts to create the Kafka clients
+	ReconnectMaxBackoffMs     int               `mapstructure:"reconnect_max_backoff_ms" yaml:"reconnect_max_backoff_ms"`           // Upper bound of the reconnect backoff
+	PublishMode               string            `mapstructure:"publish_mode" yaml:"publish_mode"`                                   // default, idempotent or transactional
+	TransactionalID           string            `mapstructure:"transactional_id" yaml:"transactional_id"`                           // Prefix of the producer transactional IDs, unique per instance. Defaults to the consumer group and host name
+}
+
+// Publish modes
+const (
+	PublishModeDefault       = "default"       // Records are published independently
+	PublishModeIdempotent    = "idempotent"    // Producer retries do not duplicate or reorder records
+	PublishModeTransactional = "transactional" // The records of an input message are published atomically
+)
+
+// Output sink types
+const (
+	SinkTypeKafka   = "kafka"   // Publishes to the Kafka topics of the routes
+	SinkTypeFile    = "file"    // Appends records to a JSON lines file
+	SinkTypeStdout  = "stdout"  // Writes records to stdout as JSON lines
+	SinkTypeWebhook = "webhook" // Posts records to an HTTP endpoint as a JSON array
+)
+
+type SinkConfig struct {
+	Name      string            `mapstructure:"name" yaml:"name"` // Name referred to by routes
+	Type      string            `mapstructure:"type" yaml:"type"` // kafka, file, stdout or webhook
+	FilePath  string            `mapstructure:"file_path" yaml:"file_path"`
+	URL       string            `mapstructure:"url" yaml:"url"`
+	Headers   map[string]string `mapstructure:"headers" yaml:"headers"`       // Extra headers of webhook requests
+	TimeoutMs int               `mapstructure:"timeout_ms" yaml:"timeout_ms"` // Timeout of webhook requests
+}
+
+// Output route, matching a record when all of its set filters match.
+// Filters list the accepted values and are compared case-insensitively. An empty filter matches any value.
+type RouteConfig struct {
+	Name        string   `mapstructure:"name" yaml:"name"`
+	Enabled     bool     `mapstructure:"enabled" yaml:"enabled"`
+	Sink        string   `mapstructure:"sink" yaml:"sink"`     // Name of the output sink, kafka when empty
+	Topics      []string `mapstructure:"topics" yaml:"topics"` // Topics a matching record is published to
+	Customers   []string `mapstructure:"customers" yaml:"customers"`
+	Sites       []string `mapstructure:"sites" yaml:"sites"`
+	DeviceTypes []string `mapstructure:"device_types" yaml:"device_types"`
+	States      []string `mapstructure:"states" yaml:"states"` // Pre (raw) or Post (processed)
+}
+
+type DatabaseConfig struct {
+	URL string `mapstructure:"url" yaml:"url"` // Overrides the DB_URL environment variable when set
+}
+
+type FeaturesConfig struct {
+	KafkaLogging   bool `mapstructure:"kafka_logging" yaml:"kafka_logging"`
+	WorkersLogging bool `mapstructure:"workers_logging" yaml:"workers_logging"`
+}
+
+type DeadLetterConfig struct {
+	Enabled bool   `mapstructure:"enabled" yaml:"enabled"`
+	Topic   string `mapstructure:"topic" yaml:"topic"`
+}
+
+type WorkersConfig struct {
+	PoolSize            int `mapstructure:"pool_size" yaml:"pool_size"`                         // Number of shards processing messages concurrently
+	QueueDepth          int `mapstructure:"queue_depth" yaml:"queue_depth"`                     // Backlog of messages per shard
+	DrainTimeoutSeconds int `mapstructure:"drain_timeout_seconds" yaml:"drain_timeout_seconds"` // Time given to in-flight messages to finish on shutdown
+}
+
+type CacheConfig struct {
+	TTLSeconds         int `mapstructure:"ttl_seconds" yaml:"ttl_seconds"`                   // Lifetime of cached devices and customers
+	NegativeTTLSeconds int `mapstructure:"negative_ttl_seconds" yaml:"negative_ttl_seconds"` // Lifetime of cached unknown devices
+}
+
+type IgnoredConfig struct {
+	FilePath              string `mapstructure:"file_path" yaml:"file_path"`
+	ReloadIntervalSeconds int    `mapstructure:"reload_interval_seconds" yaml:"reload_interval_seconds"` // How often the file is checked for changes
+}
+
+type MonitoringConfig struct {
+	Enabled                bool   `mapstructure:"enabled" yaml:"enabled"`
+	Address                string `mapstructure:"address" yaml:"address"`                                   // Listen address of the /metrics, /healthz and /readyz endpoints
+	LivenessTimeoutSeconds int    `mapstructure:"liveness_timeout_seconds" yaml:"liveness_timeout_seconds"` // Time without progress after which a worker loop is unhealthy, not counting time waiting for Kafka
+	PublishMaxAgeSeconds   int    `mapstructure:"publish_max_age_seconds" yaml:"publish_max_age_seconds"`   // Time without a successful publish after which the worker is not ready, 0 to disable
+}
+
+type AdminConfig struct {
+	Enabled     bool   `mapstructure:"enabled" yaml:"enabled"`
+	SocketPath  string `mapstructure:"socket_path" yaml:"socket_path"`   // Unix socket of the admin API
+	HTTPAddress string `mapstructure:"http_address" yaml:"http_address"` // Localhost address to also serve the admin API on, empty to disable
+}
+
+type StatsConfig struct {
+	FlushIntervalSeconds int `mapstructure:"flush_interval_seconds" yaml:"flush_interval_seconds"` // How often the runtime statistics are written to the persisted state
+}
+
+type ConnectivityConfig struct {
+	Enabled              bool                     `mapstructure:"enabled" yaml:"enabled"`
+	Sink                 string                   `mapstructure:"sink" yaml:"sink"`                                     // Output sink of the controller offline and online events
+	Topic                string                   `mapstructure:"topic" yaml:"topic"`                                   // Topic of the controller offline and online events
+	OfflineAfterSeconds  int                      `mapstructure:"offline_after_seconds" yaml:"offline_after_seconds"`   // Time without messages after which a controller is offline
+	CheckIntervalSeconds int                      `mapstructure:"check_interval_seconds" yaml:"check_interval_seconds"` // How often controllers are checked
+	Thresholds           []OfflineThresholdConfig `mapstructure:"thresholds" yaml:"thresholds"`                         // Offline thresholds of customers and sites, the most specific applies
+}
+
+type OfflineThresholdConfig struct {
+	Customer            string `mapstructure:"customer" yaml:"customer"` // Empty matches any customer
+	Site                string `mapstructure:"site" yaml:"site"`         // Empty matches any site
+	OfflineAfterSeconds int    `mapstructure:"offline_after_seconds" yaml:"offline_after_seconds"`
+}
+
+type AlarmsConfig struct {
+	Enabled bool          `mapstructure:"enabled" yaml:"enabled"`
+	Sink    string        `mapstructure:"sink" yaml:"sink"`   // Output sink of the alarm events
+	Topic   string        `mapstructure:"topic" yaml:"topic"` // Topic of the alarm events
+	Alarms  []AlarmConfig `mapstructure:"alarms" yaml:"alarms"`
+}
+
+type AlarmConfig struct {
+	Status   string `mapstructure:"status" yaml:"status"`     // Status flag of the device, e.g. Estop
+	Severity string `mapstructure:"severity" yaml:"severity"` // e.g. critical, warning or info
+}
+
+type RunSessionsConfig struct {
+	Enabled              bool    `mapstructure:"enabled" yaml:"enabled"`
+	Sink                 string  `mapstructure:"sink" yaml:"sink"`                                     // Output sink of the run sessions
+	Topic                string  `mapstructure:"topic" yaml:"topic"`                                   // Topic of the run sessions
+	MinRpm               float64 `mapstructure:"min_rpm" yaml:"min_rpm"`                               // Engine speed from which a genset is running
+	MinPowerKw           float64 `mapstructure:"min_power_kw" yaml:"min_power_kw"`                     // Output power from which a genset is running
+	MaxGapSeconds        int     `mapstructure:"max_gap_seconds" yaml:"max_gap_seconds"`               // Time without messages after which a session ends at its last running message
+	CheckIntervalSeconds int     `mapstructure:"check_interval_seconds" yaml:"check_interval_seconds"` // How often gensets are checked for sessions to end
+}
+
+type DedupConfig struct {
+	Enabled              bool   `mapstructure:"enabled" yaml:"enabled"`
+	ByDeviceTimestamp    bool   `mapstructure:"by_device_timestamp" yaml:"by_device_timestamp"`       // Also skip records of a device with an already published timestamp
+	MaxEntries           int    `mapstructure:"max_entries" yaml:"max_entries"`                       // Size of the deduplication window
+	TTLSeconds           int    `mapstructure:"ttl_seconds" yaml:"ttl_seconds"`                       // Time an entry stays in the deduplication window
+	FilePath             string `mapstructure:"file_path" yaml:"file_path"`                           // Local store of the window, so it survives restarts
+	FlushIntervalSeconds int    `mapstructure:"flush_interval_seconds" yaml:"flush_interval_seconds"` // How often the window is saved to the store
+}
+
+// Error policy actions
+const (
+	ErrorActionDrop       = "drop"
+	ErrorActionDeadLetter = "dead_letter"
+	ErrorActionRetry      = "retry"
+)
+
+type ErrorPolicyConfig struct {
+	LogLevel       string `mapstructure:"log_level" yaml:"log_level"`
+	Action         string `mapstructure:"action" yaml:"action"`                     // drop, dead_letter or retry
+	MaxRetries     int    `mapstructure:"max_retries" yaml:"max_retries"`           // Retries before falling back to the dead-letter topic
+	RetryBackoffMs int    `mapstructure:"retry_backoff_ms" yaml:"retry_backoff_ms"` // Delay before the first retry, doubled on every attempt
+}
@@ -51,15 +51,49 @@ func InitConfig() (newFiles []string, existingFiles []string, err error) {
 		return newFiles, existingFiles, err
 	}
 
+	// Initialize the overlay of the selected environment profile
+	profileFilePath := appProfileFilePath()
+	profileExists := false
+	profileExists, err = app.InitAppProfile(profileFilePath, environment())
+	if profileExists {
+		existingFiles = append(existingFiles, profileFilePath)
+	} else if coreutils.FileExists(profileFilePath) {
+		newFiles = append(newFiles, profileFilePath)
+	}
+
+	if err != nil {
+		return newFiles, existingFiles, err
+	}
+
 	return newFiles, existingFiles, nil
 }
 
 // GetConfig returns the application configuration
-func GetConfig() *Config {
+func GetConfig() (*Config, error) {
+	appCfg, err := app.GetAppConfig(appConfigFilePath, appProfileFilePath())
+	if err != nil {
+		return nil, err
+	}
+
 	return &Config{
 		System: system.GetSystemConfig(systemConfigFilePath),
-		App:    app.GetAppConfig(appConfigFilePath),
-	}
+		App:    appCfg,
+	}, nil
+}
+
+// ReloadAppConfig reads the application configuration again as on startup, without changing the current configuration
+func ReloadAppConfig() (*app.AppConfig, error) {
+	return app.LoadAppConfig(appConfigFilePath, appProfileFilePath())
+}
+
+// environment returns the normalized environment name
+func environment() string {
+	return strings.ToLower(flags.FlagEnvironment)
+}
+
+// appProfileFilePath returns the path of the app configuration overlay for the selected environment
+func appProfileFilePath() string {
+	return filepath.Join(coreutils.GetConfigDir(), fmt.Sprintf("app.%s.yaml", environment()))
 }
 
 // SaveConfig saves the configuration
@@ -74,7 +108,7 @@ func SaveConfig() error {
 
 // PrintInfo prints the application information
 func PrintInfo(versionOnly bool) {
-	systemCfg := GetConfig().System
+	systemCfg := system.GetSystemConfig(systemConfigFilePath)
 
 	goVersion := strings.Replace(runtime.Version(), "go", "", 1)
 
@@ -89,7 +123,7 @@ func PrintInfo(versionOnly bool) {
 	fmt.Println("")
 
 	if !versionOnly {
-		switch strings.ToLower(flags.FlagEnvironment) {
+		switch environment() {
 		case "development":
 			fmt.Println(textutils.ColorText(textutils.Red, (textutils.BoldText("Running in Development mode"))))
 		case "testing":
@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johandrevandeventer/dse-worker/internal/workers/types"
+)
+
+// DeviceActivity is when a device was last seen
+type DeviceActivity struct {
+	Device        string    `json:"device"`
+	Controller    string    `json:"controller"`
+	DeviceType    string    `json:"device_type"`
+	Customer      string    `json:"customer"`
+	Site          string    `json:"site"`
+	LastSeen      time.Time `json:"last_seen"`      // When the last message of the device was processed
+	LastTimestamp time.Time `json:"last_timestamp"` // Timestamp of the last message of the device
+	Messages      uint64    `json:"messages"`
+}
+
+// deviceTracker tracks the activity of the devices seen since the engine started
+type deviceTracker struct {
+	mu      sync.Mutex
+	devices map[string]*DeviceActivity
+}
+
+func newDeviceTracker() *deviceTracker {
+	return &deviceTracker{
+		devices: make(map[string]*DeviceActivity),
+	}
+}
+
+// seen records a message of a device
+func (t *deviceTracker) seen(device types.Device, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	activity, ok := t.devices[device.DeviceIdentifier]
+	if !ok {
+		activity = &DeviceActivity{Device: device.DeviceIdentifier}
+		t.devices[device.DeviceIdentifier] = activity
+	}
+
+	activity.Controller = device.ControllerIdentifier
+	activity.DeviceType = device.DeviceType
+	activity.Customer = device.CustomerName
+	activity.Site = device.SiteName
+	activity.LastSeen = at
+	if device.Timestamp.After(activity.LastTimestamp) {
+		activity.LastTimestamp = device.Timestamp
+	}
+	activity.Messages++
+}
+
+// list returns the activity of every device, ordered by device
+func (t *deviceTracker) list() []DeviceActivity {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	activities := make([]DeviceActivity, 0, len(t.devices))
+	for _, activity := range t.devices {
+		activities = append(activities, *activity)
+	}
+
+	slices.SortFunc(activities, func(a, b DeviceActivity) int {
+		return strings.Compare(a.Device, b.Device)
+	})
+
+	return activities
+}
+
+// restore adds the activity of devices seen before a restart, keeping the devices already seen since
+func (t *deviceTracker) restore(activities []DeviceActivity) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, activity := range activities {
+		if _, ok := t.devices[activity.Device]; ok || activity.Device == "" {
+			continue
+		}
+
+		restored := activity
+		t.devices[activity.Device] = &restored
+	}
+}
@@ -0,0 +1,351 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/johandrevandeventer/dse-worker/internal/config"
+	"github.com/johandrevandeventer/dse-worker/internal/config/app"
+	"github.com/johandrevandeventer/dse-worker/internal/workers"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+)
+
+// AddIgnoredControllerRequest is the body of a request to ignore a controller
+type AddIgnoredControllerRequest struct {
+	ID        string     `json:"id"`
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// PauseResponse is the response to a pause or resume request
+type PauseResponse struct {
+	Paused  bool `json:"paused"`
+	Changed bool `json:"changed"` // False if consumption already was paused or resumed
+}
+
+// redactedValue replaces the secrets in the configuration returned by the admin API
+const redactedValue = "[redacted]"
+
+type adminError struct {
+	Error string `json:"error"`
+}
+
+// startAdminServer serves the admin API on the admin socket, and on the localhost HTTP address if set, until the engine stops
+func (e *Engine) startAdminServer() {
+	adminCfg := e.cfg.App.Admin
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /config", e.handleAdminConfig)
+	mux.HandleFunc("GET /status", e.handleAdminStatus)
+	mux.HandleFunc("GET /state", e.handleAdminState)
+	mux.HandleFunc("GET /devices", e.handleAdminDevices)
+	mux.HandleFunc("GET /ignored", e.handleAdminIgnored)
+	mux.HandleFunc("POST /ignored/controllers", e.handleAdminAddIgnoredController)
+	mux.HandleFunc("DELETE /ignored/controllers/{id}", e.handleAdminRemoveIgnoredController)
+	mux.HandleFunc("POST /pause", e.handleAdminPause(true))
+	mux.HandleFunc("POST /resume", e.handleAdminPause(false))
+	mux.HandleFunc("POST /cache/flush", e.handleAdminFlushCache)
+
+	var listeners []net.Listener
+
+	listener, err := listenAdminSocket(adminCfg.SocketPath)
+	if err != nil {
+		e.logger.Error("Failed to listen on admin socket", zap.String("path", filepath.ToSlash(adminCfg.SocketPath)), zap.Error(err))
+	} else {
+		listeners = append(listeners, listener)
+		defer os.Remove(adminCfg.SocketPath)
+	}
+
+	if adminCfg.HTTPAddress != "" {
+		listener, err := listenAdminHTTP(adminCfg.HTTPAddress)
+		if err != nil {
+			e.logger.Error("Failed to listen on admin address", zap.String("address", adminCfg.HTTPAddress), zap.Error(err))
+		} else {
+			listeners = append(listeners, listener)
+		}
+	}
+
+	if len(listeners) == 0 {
+		return
+	}
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	errCh := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		e.logger.Info("Starting admin server", zap.String("address", listener.Addr().String()))
+		go func() {
+			errCh <- server.Serve(listener)
+		}()
+	}
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, http.ErrServerClosed) {
+			e.logger.Error("Admin server failed", zap.Error(err))
+		}
+	case <-e.ctx.Done():
+	}
+
+	// Gracefully shut down the server with a timeout
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		e.logger.Error("Failed to shut down admin server", zap.Error(err))
+	}
+}
+
+// listenAdminSocket listens on the admin socket, replacing the socket left behind by an instance that did not stop cleanly
+func listenAdminSocket(socketPath string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0o770); err != nil {
+		return nil, err
+	}
+
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("admin socket is in use by another instance")
+	}
+	os.Remove(socketPath)
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the user running the worker may use the admin API
+	if err := os.Chmod(socketPath, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// listenAdminHTTP listens on a localhost address. Other addresses are refused, the admin API has no authentication.
+func listenAdminHTTP(address string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("admin address must be on localhost")
+	}
+
+	return net.Listen("tcp", address)
+}
+
+// handleAdminConfig returns the effective configuration as YAML, like the configuration files, without secrets
+func (e *Engine) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	appCfg := redactConfig(*e.appConfig())
+
+	data, err := yaml.Marshal(config.Config{System: e.cfg.System, App: &appCfg})
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, fmt.Errorf("failed to serialize configuration: %w", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(data)
+}
+
+// handleAdminStatus returns the runtime state of the engine
+func (e *Engine) handleAdminStatus(w http.ResponseWriter, r *http.Request) {
+	writeAdminResponse(w, http.StatusOK, e.StateSnapshot())
+}
+
+// handleAdminState returns the persisted state, as written to the persist file
+func (e *Engine) handleAdminState(w http.ResponseWriter, r *http.Request) {
+	data, err := os.ReadFile(e.cfg.App.Runtime.PersistFilePath)
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, fmt.Errorf("failed to read persisted state: %w", err))
+		return
+	}
+
+	if !json.Valid(data) {
+		writeAdminError(w, http.StatusInternalServerError, fmt.Errorf("persisted state is not valid JSON"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// handleAdminDevices returns when every device was last seen
+func (e *Engine) handleAdminDevices(w http.ResponseWriter, r *http.Request) {
+	writeAdminResponse(w, http.StatusOK, e.devices.list())
+}
+
+// handleAdminIgnored returns the ignore list
+func (e *Engine) handleAdminIgnored(w http.ResponseWriter, r *http.Request) {
+	ignoredFile, err := workers.GetIgnoredFile()
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeAdminResponse(w, http.StatusOK, ignoredFile)
+}
+
+// handleAdminAddIgnoredController adds a rule ignoring a controller to the ignore file
+func (e *Engine) handleAdminAddIgnoredController(w http.ResponseWriter, r *http.Request) {
+	var request AddIgnoredControllerRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	if request.ID == "" {
+		writeAdminError(w, http.StatusBadRequest, fmt.Errorf("controller ID is required"))
+		return
+	}
+
+	err := e.runControlAction("ignore-controller "+request.ID, func() error {
+		return workers.AddIgnoredController(request.ID, request.Reason, request.ExpiresAt)
+	})
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	e.handleAdminIgnored(w, r)
+}
+
+// handleAdminRemoveIgnoredController removes a controller from the ignore file
+func (e *Engine) handleAdminRemoveIgnoredController(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	var removed bool
+	err := e.runControlAction("unignore-controller "+id, func() error {
+		var err error
+		removed, err = workers.RemoveIgnoredController(id)
+		return err
+	})
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !removed {
+		writeAdminError(w, http.StatusNotFound, fmt.Errorf("controller %s is not ignored", id))
+		return
+	}
+
+	e.handleAdminIgnored(w, r)
+}
+
+// handleAdminPause returns a handler that pauses or resumes consumption
+func (e *Engine) handleAdminPause(paused bool) http.HandlerFunc {
+	name := "resume"
+	if paused {
+		name = "pause"
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var changed bool
+		e.runControlAction(name, func() error {
+			changed = e.SetPaused(paused)
+			return nil
+		})
+
+		writeAdminResponse(w, http.StatusOK, PauseResponse{Paused: paused, Changed: changed})
+	}
+}
+
+// handleAdminFlushCache flushes the device cache and returns its statistics before the flush
+func (e *Engine) handleAdminFlushCache(w http.ResponseWriter, r *http.Request) {
+	var stats workers.CacheStats
+	e.runControlAction("flush-cache", func() error {
+		stats = e.FlushCache()
+		return nil
+	})
+
+	writeAdminResponse(w, http.StatusOK, stats)
+}
+
+// redactConfig returns a copy of the app configuration without secrets: the database URL, the values of the Kafka client
+// options and webhook headers, which carry credentials such as sasl.password and Authorization, and the password and
+// query values of sink URLs
+func redactConfig(appCfg app.AppConfig) app.AppConfig {
+	if appCfg.Database.URL != "" {
+		appCfg.Database.URL = redactedValue
+	}
+
+	appCfg.Kafka.ClientOptions = redactValues(appCfg.Kafka.ClientOptions)
+	appCfg.Kafka.ConsumerOptions = redactValues(appCfg.Kafka.ConsumerOptions)
+	appCfg.Kafka.ProducerOptions = redactValues(appCfg.Kafka.ProducerOptions)
+
+	sinks := make([]app.SinkConfig, len(appCfg.Sinks))
+	for i, sink := range appCfg.Sinks {
+		sink.Headers = redactValues(sink.Headers)
+		sink.URL = redactURL(sink.URL)
+		sinks[i] = sink
+	}
+	appCfg.Sinks = sinks
+
+	return appCfg
+}
+
+// redactValues returns a copy of a map with its keys and redacted values
+func redactValues(values map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+
+	redacted := make(map[string]string, len(values))
+	for key := range values {
+		redacted[key] = redactedValue
+	}
+
+	return redacted
+}
+
+// redactURL returns a URL without its password and query values. A URL that does not parse is redacted entirely.
+func redactURL(rawURL string) string {
+	if rawURL == "" {
+		return ""
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return redactedValue
+	}
+
+	if u.RawQuery != "" {
+		query := u.Query()
+		for key := range query {
+			query.Set(key, redactedValue)
+		}
+		u.RawQuery = query.Encode()
+	}
+
+	return u.Redacted()
+}
+
+// writeAdminResponse writes a JSON response
+func writeAdminResponse(w http.ResponseWriter, status int, response any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(response)
+}
+
+// writeAdminError writes an error as a JSON response
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	writeAdminResponse(w, status, adminError{Error: err.Error()})
+}
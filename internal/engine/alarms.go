@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/johandrevandeventer/dse-worker/internal/workers/types"
+	"go.uber.org/zap"
+)
+
+// alarmTransition is an alarm of a device being raised or cleared
+type alarmTransition struct {
+	device string
+	alarm  string
+	raised bool
+	at     time.Time
+}
+
+// alarmTracker tracks the active alarms of every device, with the time they were raised
+type alarmTracker struct {
+	mu     sync.Mutex
+	active map[string]map[string]time.Time // Raised time by alarm, by device
+}
+
+func newAlarmTracker() *alarmTracker {
+	return &alarmTracker{
+		active: make(map[string]map[string]time.Time),
+	}
+}
+
+// raisedAt returns when an active alarm of a device was raised, and false if the alarm is not active
+func (t *alarmTracker) raisedAt(device string, alarm string) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	raisedAt, ok := t.active[device][alarm]
+	return raisedAt, ok
+}
+
+// apply records alarm transitions once their events are published
+func (t *alarmTracker) apply(transitions []alarmTransition) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, transition := range transitions {
+		if !transition.raised {
+			delete(t.active[transition.device], transition.alarm)
+			if len(t.active[transition.device]) == 0 {
+				delete(t.active, transition.device)
+			}
+			continue
+		}
+
+		if t.active[transition.device] == nil {
+			t.active[transition.device] = make(map[string]time.Time)
+		}
+		t.active[transition.device][transition.alarm] = transition.at
+	}
+}
+
+// snapshot returns the active alarms of every device
+func (t *alarmTracker) snapshot() map[string]map[string]time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	active := make(map[string]map[string]time.Time, len(t.active))
+	for device, alarms := range t.active {
+		active[device] = maps.Clone(alarms)
+	}
+
+	return active
+}
+
+// restore adds the active alarms from before a restart
+func (t *alarmTracker) restore(active map[string]map[string]time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for device, alarms := range active {
+		if _, ok := t.active[device]; !ok && len(alarms) > 0 {
+			t.active[device] = maps.Clone(alarms)
+		}
+	}
+}
+
+// alarmRecords returns the events of the alarms of devices that were raised or cleared, with the transitions to apply once
+// the events are published. Alarms are edge-triggered, so an alarm that stays active is only reported when raised and cleared.
+func (e *Engine) alarmRecords(devices []types.Device) ([]Record, []alarmTransition, error) {
+	alarmsCfg := e.cfg.App.Alarms
+	if !alarmsCfg.Enabled {
+		return nil, nil, nil
+	}
+
+	var records []Record
+	var transitions []alarmTransition
+	for _, device := range devices {
+		for _, alarm := range alarmsCfg.Alarms {
+			active, ok := device.Statuses[alarm.Status]
+			if !ok {
+				continue
+			}
+
+			raisedAt, wasActive := e.alarms.raisedAt(device.DeviceIdentifier, alarm.Status)
+			if active == wasActive {
+				continue
+			}
+
+			event := types.AlarmEvent{
+				Alarm:                alarm.Status,
+				Severity:             alarm.Severity,
+				CustomerID:           device.CustomerID,
+				CustomerName:         device.CustomerName,
+				SiteID:               device.SiteID,
+				SiteName:             device.SiteName,
+				Controller:           device.Controller,
+				DeviceType:           device.DeviceType,
+				ControllerIdentifier: device.ControllerIdentifier,
+				DeviceName:           device.DeviceName,
+				DeviceIdentifier:     device.DeviceIdentifier,
+				Timestamp:            device.Timestamp,
+			}
+
+			if active {
+				event.Event = types.EventAlarmRaised
+				event.RaisedAt = device.Timestamp
+			} else {
+				clearedAt := device.Timestamp
+				event.Event = types.EventAlarmCleared
+				event.RaisedAt = raisedAt
+				event.ClearedAt = &clearedAt
+				event.DurationSeconds = clearedAt.Sub(raisedAt).Seconds()
+			}
+
+			record, err := eventRecord(alarmsCfg.Sink, alarmsCfg.Topic, event, device.Timestamp)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			records = append(records, record)
+			transitions = append(transitions, alarmTransition{device: device.DeviceIdentifier, alarm: alarm.Status, raised: active, at: device.Timestamp})
+		}
+	}
+
+	return records, transitions, nil
+}
+
+// applyAlarmTransitions records published alarm transitions and persists the active alarms
+func (e *Engine) applyAlarmTransitions(transitions []alarmTransition) {
+	if len(transitions) == 0 {
+		return
+	}
+
+	e.alarms.apply(transitions)
+	for _, transition := range transitions {
+		event := types.EventAlarmCleared
+		if transition.raised {
+			event = types.EventAlarmRaised
+		}
+
+		alarmEvents.WithLabelValues(transition.alarm, event).Inc()
+		e.logger.Info("Device alarm changed", zap.String("device", transition.device), zap.String("alarm", transition.alarm), zap.String("event", event))
+	}
+
+	e.saveAlarms()
+}
+
+// restoreAlarms restores the active alarms of the previous run from the persist file, so that they are not raised again.
+// It must run before the app state is reset.
+func (e *Engine) restoreAlarms() {
+	var active map[string]map[string]time.Time
+	found, err := readPersistedValue(e.cfg.App.Runtime.PersistFilePath, "app.alarms", &active)
+	if err != nil {
+		e.logger.Error("Failed to restore active alarms, starting without them", zap.Error(err))
+		return
+	}
+
+	if found {
+		e.alarms.restore(active)
+		e.verboseDebug("Active alarms restored", zap.Int("devices", len(active)))
+	}
+}
+
+// saveAlarms writes the active alarms to the persisted app state
+func (e *Engine) saveAlarms() {
+	e.statePersister.Set("app.alarms", e.alarms.snapshot())
+}
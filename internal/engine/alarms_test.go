@@ -0,0 +1,232 @@
+package engine
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/johandrevandeventer/dse-worker/internal/config/app"
+	"github.com/johandrevandeventer/dse-worker/internal/workers/types"
+)
+
+// testAlarmsConfig raises Estop and MainFail alarms
+var testAlarmsConfig = app.AlarmsConfig{
+	Enabled: true,
+	Sink:    defaultSinkName,
+	Topic:   "alarms",
+	Alarms: []app.AlarmConfig{
+		{Status: "Estop", Severity: "critical"},
+		{Status: "MainFail", Severity: "warning"},
+	},
+}
+
+func alarmEvent(t *testing.T, record Record) types.AlarmEvent {
+	t.Helper()
+
+	var event types.AlarmEvent
+	decodeRecord(t, record, &event)
+	return event
+}
+
+func TestAlarmRecords(t *testing.T) {
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	device := func(minutes int, statuses map[string]bool) types.Device {
+		return types.Device{DeviceIdentifier: "genset-1", Timestamp: start.Add(time.Duration(minutes) * time.Minute), Statuses: statuses}
+	}
+
+	// wantEvent is an alarm event expected of a message, by alarm and event
+	type wantEvent struct {
+		alarm string
+		event string
+	}
+
+	tests := []struct {
+		name    string
+		devices []types.Device
+		want    [][]wantEvent // Events expected of every message, in order
+	}{
+		{
+			name: "raised and cleared once",
+			devices: []types.Device{
+				device(0, map[string]bool{"Estop": false}),
+				device(1, map[string]bool{"Estop": true}),
+				device(2, map[string]bool{"Estop": true}),
+				device(3, map[string]bool{"Estop": false}),
+				device(4, map[string]bool{"Estop": false}),
+			},
+			want: [][]wantEvent{
+				nil,
+				{{"Estop", types.EventAlarmRaised}},
+				nil,
+				{{"Estop", types.EventAlarmCleared}},
+				nil,
+			},
+		},
+		{
+			name: "missing status keeps the alarm state",
+			devices: []types.Device{
+				device(0, map[string]bool{"Estop": true}),
+				device(1, nil),
+				device(2, map[string]bool{"Estop": true}),
+			},
+			want: [][]wantEvent{
+				{{"Estop", types.EventAlarmRaised}},
+				nil,
+				nil,
+			},
+		},
+		{
+			name: "alarms are independent",
+			devices: []types.Device{
+				device(0, map[string]bool{"Estop": true, "MainFail": false}),
+				device(1, map[string]bool{"Estop": true, "MainFail": true}),
+				device(2, map[string]bool{"Estop": false, "MainFail": true}),
+			},
+			want: [][]wantEvent{
+				{{"Estop", types.EventAlarmRaised}},
+				{{"MainFail", types.EventAlarmRaised}},
+				{{"Estop", types.EventAlarmCleared}},
+			},
+		},
+		{
+			name: "statuses without alarm are ignored",
+			devices: []types.Device{
+				device(0, map[string]bool{"FailStart": true}),
+			},
+			want: [][]wantEvent{nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEngine(t, app.AppConfig{Alarms: testAlarmsConfig})
+
+			for i, d := range tt.devices {
+				records, transitions, err := e.alarmRecords([]types.Device{d})
+				if err != nil {
+					t.Fatalf("message %d: %v", i, err)
+				}
+				if len(records) != len(tt.want[i]) || len(transitions) != len(tt.want[i]) {
+					t.Fatalf("message %d: %d records and %d transitions, want %d", i, len(records), len(transitions), len(tt.want[i]))
+				}
+
+				for j, want := range tt.want[i] {
+					event := alarmEvent(t, records[j])
+					if event.Alarm != want.alarm || event.Event != want.event {
+						t.Errorf("message %d: event %s of %s, want %s of %s", i, event.Event, event.Alarm, want.event, want.alarm)
+					}
+					if records[j].Sink != defaultSinkName || records[j].Topic != "alarms" {
+						t.Errorf("message %d: record to %s/%s, want %s/alarms", i, records[j].Sink, records[j].Topic, defaultSinkName)
+					}
+				}
+
+				// The events are published before the next message
+				e.alarms.apply(transitions)
+			}
+		})
+	}
+}
+
+func TestAlarmRecordsUnpublished(t *testing.T) {
+	e := newTestEngine(t, app.AppConfig{Alarms: testAlarmsConfig})
+	d := types.Device{DeviceIdentifier: "genset-1", Timestamp: time.Now(), Statuses: map[string]bool{"Estop": true}}
+
+	// An alarm whose event failed to publish is raised again by the next message
+	for i := 0; i < 2; i++ {
+		records, _, err := e.alarmRecords([]types.Device{d})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 1 {
+			t.Errorf("attempt %d: %d records, want the raised event", i, len(records))
+		}
+	}
+}
+
+func TestAlarmRecordsDuration(t *testing.T) {
+	e := newTestEngine(t, app.AppConfig{Alarms: testAlarmsConfig})
+	raisedAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	clearedAt := raisedAt.Add(90 * time.Second)
+
+	_, transitions, err := e.alarmRecords([]types.Device{{DeviceIdentifier: "genset-1", Timestamp: raisedAt, Statuses: map[string]bool{"Estop": true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.alarms.apply(transitions)
+
+	records, _, err := e.alarmRecords([]types.Device{{DeviceIdentifier: "genset-1", Timestamp: clearedAt, Statuses: map[string]bool{"Estop": false}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("%d records, want the cleared event", len(records))
+	}
+
+	event := alarmEvent(t, records[0])
+	if !event.RaisedAt.Equal(raisedAt) {
+		t.Errorf("RaisedAt = %v, want %v", event.RaisedAt, raisedAt)
+	}
+	if event.ClearedAt == nil || !event.ClearedAt.Equal(clearedAt) {
+		t.Errorf("ClearedAt = %v, want %v", event.ClearedAt, clearedAt)
+	}
+	if event.DurationSeconds != 90 {
+		t.Errorf("DurationSeconds = %v, want 90", event.DurationSeconds)
+	}
+	if event.Severity != "critical" {
+		t.Errorf("Severity = %s, want critical", event.Severity)
+	}
+}
+
+func TestAlarmRecordsAfterRestore(t *testing.T) {
+	raisedAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// The alarm was raised before the restart
+	previous := newTestEngine(t, app.AppConfig{Alarms: testAlarmsConfig})
+	_, transitions, err := previous.alarmRecords([]types.Device{{DeviceIdentifier: "genset-1", Timestamp: raisedAt, Statuses: map[string]bool{"Estop": true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous.alarms.apply(transitions)
+
+	// The persisted state goes through JSON
+	data, err := json.Marshal(previous.alarms.snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var active map[string]map[string]time.Time
+	if err := json.Unmarshal(data, &active); err != nil {
+		t.Fatal(err)
+	}
+
+	e := newTestEngine(t, app.AppConfig{Alarms: testAlarmsConfig})
+	e.alarms.restore(active)
+
+	records, _, err := e.alarmRecords([]types.Device{{DeviceIdentifier: "genset-1", Timestamp: raisedAt.Add(time.Hour), Statuses: map[string]bool{"Estop": true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("%d records, want the restored alarm not raised again", len(records))
+	}
+
+	// Clearing it reports the duration from before the restart
+	records, _, err = e.alarmRecords([]types.Device{{DeviceIdentifier: "genset-1", Timestamp: raisedAt.Add(2 * time.Hour), Statuses: map[string]bool{"Estop": false}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("%d records, want the cleared event", len(records))
+	}
+	if event := alarmEvent(t, records[0]); event.DurationSeconds != 2*time.Hour.Seconds() {
+		t.Errorf("DurationSeconds = %v, want %v", event.DurationSeconds, 2*time.Hour.Seconds())
+	}
+
+	// Alarms restored for a device that is already tracked do not override it
+	e.alarms.restore(map[string]map[string]time.Time{"genset-1": {"Estop": raisedAt.Add(time.Hour), "MainFail": raisedAt}})
+	if got, _ := e.alarms.raisedAt("genset-1", "Estop"); !got.Equal(raisedAt) {
+		t.Errorf("Estop raised at %v after a second restore, want %v", got, raisedAt)
+	}
+	if _, ok := e.alarms.raisedAt("genset-1", "MainFail"); ok {
+		t.Error("alarm of a tracked device restored")
+	}
+}
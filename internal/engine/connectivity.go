@@ -0,0 +1,268 @@
+package engine
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/johandrevandeventer/dse-worker/internal/config/app"
+	"github.com/johandrevandeventer/dse-worker/internal/workers/types"
+	"go.uber.org/zap"
+)
+
+// controllerConnectivity is the connectivity state of a controller
+type controllerConnectivity struct {
+	Controller   types.Controller `json:"controller"`
+	DeviceType   string           `json:"device_type"`
+	CustomerID   uuid.UUID        `json:"customer_id"`
+	CustomerName string           `json:"customer_name"`
+	SiteID       uuid.UUID        `json:"site_id"`
+	SiteName     string           `json:"site_name"`
+	Offline      bool             `json:"offline"`
+	OfflineSince *time.Time       `json:"offline_since,omitempty"` // Last message before the controller went quiet
+}
+
+// connectivityTracker tracks when every controller was last seen and whether it is offline
+type connectivityTracker struct {
+	mu          sync.Mutex
+	controllers map[string]*controllerConnectivity
+}
+
+func newConnectivityTracker() *connectivityTracker {
+	return &connectivityTracker{
+		controllers: make(map[string]*controllerConnectivity),
+	}
+}
+
+// controllerKey returns the identifier a device's controller is tracked by
+func controllerKey(device types.Device) string {
+	if device.ControllerIdentifier != "" {
+		return device.ControllerIdentifier
+	}
+
+	return device.DeviceIdentifier
+}
+
+// seen records a message of the controller of a device
+func (t *connectivityTracker) seen(device types.Device, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := controllerKey(device)
+	state, ok := t.controllers[key]
+	if !ok {
+		state = &controllerConnectivity{}
+		t.controllers[key] = state
+	}
+
+	state.Controller.ID = key
+	state.Controller.Name = device.Controller
+	state.Controller.LastSeen = at
+	state.DeviceType = device.DeviceType
+	state.CustomerID = device.CustomerID
+	state.CustomerName = device.CustomerName
+	state.SiteID = device.SiteID
+	state.SiteName = device.SiteName
+}
+
+// snapshot returns the state of every controller
+func (t *connectivityTracker) snapshot() map[string]controllerConnectivity {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	controllers := make(map[string]controllerConnectivity, len(t.controllers))
+	for key, state := range t.controllers {
+		controllers[key] = *state
+	}
+
+	return controllers
+}
+
+// restore adds the state of controllers from before a restart
+func (t *connectivityTracker) restore(controllers map[string]controllerConnectivity) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, state := range controllers {
+		if _, ok := t.controllers[key]; ok {
+			continue
+		}
+
+		restored := state
+		t.controllers[key] = &restored
+	}
+}
+
+// setOffline marks a controller offline since its last message before it went quiet
+func (t *connectivityTracker) setOffline(key string, since time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if state, ok := t.controllers[key]; ok {
+		state.Offline = true
+		state.OfflineSince = &since
+	}
+}
+
+// setOnline marks a controller online
+func (t *connectivityTracker) setOnline(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if state, ok := t.controllers[key]; ok {
+		state.Offline = false
+		state.OfflineSince = nil
+	}
+}
+
+// offlineThreshold returns the offline threshold of a controller. A threshold of a site and customer is the most
+// specific, followed by one of a site and one of a customer. Without a matching threshold the default applies.
+func offlineThreshold(cfg app.ConnectivityConfig, customer string, site string) time.Duration {
+	seconds := cfg.OfflineAfterSeconds
+	best := -1
+	for _, threshold := range cfg.Thresholds {
+		if threshold.Customer != "" && !strings.EqualFold(threshold.Customer, customer) {
+			continue
+		}
+		if threshold.Site != "" && !strings.EqualFold(threshold.Site, site) {
+			continue
+		}
+
+		specificity := 0
+		if threshold.Site != "" {
+			specificity += 2
+		}
+		if threshold.Customer != "" {
+			specificity++
+		}
+
+		if specificity > best {
+			best = specificity
+			seconds = threshold.OfflineAfterSeconds
+		}
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// restoreConnectivity restores the controller connectivity state of the previous run from the persist file.
+// It must run before the app state is reset.
+func (e *Engine) restoreConnectivity() {
+	var controllers map[string]controllerConnectivity
+	found, err := readPersistedValue(e.cfg.App.Runtime.PersistFilePath, "app.connectivity", &controllers)
+	if err != nil {
+		e.logger.Error("Failed to restore controller connectivity, starting without it", zap.Error(err))
+		return
+	}
+
+	if found {
+		e.connectivity.restore(controllers)
+		e.verboseDebug("Controller connectivity restored", zap.Int("controllers", len(controllers)))
+	}
+}
+
+// saveConnectivity writes the controller connectivity state to the persisted app state
+func (e *Engine) saveConnectivity() {
+	e.statePersister.Set("app.connectivity", e.connectivity.snapshot())
+}
+
+// consumptionSuspended reports whether messages are not being consumed, so that quiet controllers say nothing about their connectivity
+func (e *Engine) consumptionSuspended() bool {
+	if e.paused.Load() {
+		return true
+	}
+
+	select {
+	case <-e.kafkaConsumerConnectedCh:
+	default:
+		return true
+	}
+
+	state, ok := e.connections.get(kafkaClientConsumer)
+	return ok && state.State == connectionStateWaiting
+}
+
+// WatchConnectivity reports controllers that go quiet past their offline threshold, and controllers that come back.
+// Time during which the worker did not run or consume counts as quiet for no controller.
+func (e *Engine) WatchConnectivity() {
+	interval := time.Duration(max(e.cfg.App.Connectivity.CheckIntervalSeconds, 1)) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Controllers are only quiet since the baseline, which moves along while consumption is suspended
+	baseline := time.Now()
+
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+			if e.consumptionSuspended() {
+				baseline = time.Now()
+				continue
+			}
+
+			e.checkConnectivity(baseline)
+			e.saveConnectivity()
+		}
+	}
+}
+
+// checkConnectivity publishes an event for every controller whose connectivity changed.
+// A change is only recorded once its event is published, so that a failed event is retried on the next check.
+func (e *Engine) checkConnectivity(baseline time.Time) {
+	cfg := e.cfg.App.Connectivity
+	now := time.Now()
+
+	for key, state := range e.connectivity.snapshot() {
+		threshold := offlineThreshold(cfg, state.CustomerName, state.SiteName)
+
+		event := types.ControllerEvent{
+			Controller:       state.Controller,
+			DeviceType:       state.DeviceType,
+			CustomerID:       state.CustomerID,
+			CustomerName:     state.CustomerName,
+			SiteID:           state.SiteID,
+			SiteName:         state.SiteName,
+			ThresholdSeconds: int(threshold.Seconds()),
+			Timestamp:        now,
+		}
+
+		switch {
+		case state.Offline && state.OfflineSince != nil && state.Controller.LastSeen.After(*state.OfflineSince):
+			event.Event = types.EventControllerOnline
+			event.OfflineSince = *state.OfflineSince
+			event.OfflineSeconds = state.Controller.LastSeen.Sub(*state.OfflineSince).Seconds()
+		case !state.Offline && threshold > 0 && now.Sub(latest(state.Controller.LastSeen, baseline)) > threshold:
+			event.Event = types.EventControllerOffline
+			event.OfflineSince = state.Controller.LastSeen
+		default:
+			continue
+		}
+
+		err := e.publishEvent(cfg.Sink, cfg.Topic, event, now)
+		if err != nil {
+			e.logger.Error("Failed to publish controller event, retrying on the next check", zap.String("event", event.Event), zap.String("controller", key), zap.Error(err))
+			continue
+		}
+
+		if event.Event == types.EventControllerOffline {
+			e.connectivity.setOffline(key, event.OfflineSince)
+			e.logger.Warn("Controller offline", zap.String("controller", key), zap.String("customer", state.CustomerName), zap.String("site", state.SiteName), zap.Time("last_seen", state.Controller.LastSeen))
+		} else {
+			e.connectivity.setOnline(key)
+			e.logger.Info("Controller back online", zap.String("controller", key), zap.String("customer", state.CustomerName), zap.String("site", state.SiteName), zap.Float64("offline_seconds", event.OfflineSeconds))
+		}
+		controllerEvents.WithLabelValues(event.Event).Inc()
+	}
+}
+
+// latest returns the later of two times
+func latest(a time.Time, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,255 @@
+package engine
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/johandrevandeventer/dse-worker/internal/config/app"
+	"github.com/johandrevandeventer/kafkaclient/payload"
+	"go.uber.org/zap"
+)
+
+const (
+	// consumerPollTimeout is how long a poll waits for a message
+	consumerPollTimeout = 100 * time.Millisecond
+	// consumerPauseDelay is how long the output channel stays full before fetching is paused
+	consumerPauseDelay = time.Second
+)
+
+// message is an input message, acknowledged once it has been fully handled
+type message struct {
+	value []byte
+	ack   func()
+
+	// Set by the dispatcher, which deserializes the message once to pick its shard
+	payload    *payload.Payload
+	payloadErr error
+}
+
+// kafkaConsumer consumes the input topics and stores the offset of a message only once it is acknowledged.
+// Stored offsets are committed in the background by the Kafka client.
+type kafkaConsumer struct {
+	ctx      context.Context
+	consumer *kafka.Consumer
+	topics   []string
+	logger   *zap.Logger
+	outputCh chan<- *message
+	offsets  *offsetTracker
+
+	// Called when the brokers become unavailable or available again
+	onConnectionState func(connected bool, err error)
+	brokersDown       bool
+}
+
+func newKafkaConsumer(ctx context.Context, cfg app.KafkaConfig, logger *zap.Logger, outputCh chan<- *message) (*kafkaConsumer, error) {
+	configMap := &kafka.ConfigMap{
+		"bootstrap.servers":        strings.Join(cfg.Brokers, ","),
+		"group.id":                 cfg.ConsumerGroup,
+		"auto.offset.reset":        "earliest",
+		"enable.auto.commit":       true,
+		"enable.auto.offset.store": false,
+		"statistics.interval.ms":   int(kafkaStatisticsInterval.Milliseconds()),
+		"log_level":                0,
+	}
+
+	if cfg.SessionTimeoutMs > 0 {
+		configMap.SetKey("session.timeout.ms", cfg.SessionTimeoutMs)
+	}
+
+	if err := setKafkaOptions(configMap, cfg, cfg.ConsumerOptions); err != nil {
+		return nil, err
+	}
+
+	consumer, err := kafka.NewConsumer(configMap)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := probeKafka(consumer, cfg); err != nil {
+		consumer.Close()
+		return nil, err
+	}
+
+	logger.Info("Kafka consumer created successfully")
+
+	return &kafkaConsumer{
+		ctx:      ctx,
+		consumer: consumer,
+		topics:   cfg.InputTopics,
+		logger:   logger,
+		outputCh: outputCh,
+		offsets:  newOffsetTracker(),
+	}, nil
+}
+
+// Subscribe subscribes to the input topics
+func (kc *kafkaConsumer) Subscribe() error {
+	err := kc.consumer.SubscribeTopics(kc.topics, kc.rebalance)
+	if err != nil {
+		return err
+	}
+
+	kc.logger.Info("Successfully subscribed to Kafka topics", zap.Strings("topics", kc.topics))
+	return nil
+}
+
+// Run consumes messages until the context is cancelled
+func (kc *kafkaConsumer) Run() {
+	kc.consumeMessages()
+}
+
+// rebalance forgets the in-flight messages of partitions that are assigned or revoked
+func (kc *kafkaConsumer) rebalance(consumer *kafka.Consumer, event kafka.Event) error {
+	switch e := event.(type) {
+	case kafka.AssignedPartitions:
+		kc.logger.Info("Partitions assigned", zap.Int("partitions", len(e.Partitions)))
+		kc.offsets.reset(e.Partitions)
+		kc.setBrokersDown(false, nil)
+	case kafka.RevokedPartitions:
+		kc.logger.Info("Partitions revoked", zap.Int("partitions", len(e.Partitions)))
+		kc.offsets.reset(e.Partitions)
+	}
+
+	return nil
+}
+
+// consumeMessages polls messages and hands them to the output channel.
+// Fetching is paused once the output channel stays full for a while, or the messages waiting for it fill it again.
+// Polling continues to serve group events.
+func (kc *kafkaConsumer) consumeMessages() {
+	var pending []*message
+	var fullSince time.Time
+	paused := false
+
+	for {
+		pending = kc.deliver(pending)
+
+		switch {
+		case len(pending) == 0:
+			fullSince = time.Time{}
+			if paused {
+				kc.setPaused(false)
+				paused = false
+			}
+		case fullSince.IsZero():
+			fullSince = time.Now()
+		case !paused && (time.Since(fullSince) >= consumerPauseDelay || len(pending) >= cap(kc.outputCh)):
+			kc.setPaused(true)
+			paused = true
+		}
+
+		select {
+		case <-kc.ctx.Done():
+			kc.logger.Info("Stopping message consumption")
+			return
+		default:
+		}
+
+		ev := kc.consumer.Poll(int(consumerPollTimeout.Milliseconds()))
+		switch e := ev.(type) {
+		case *kafka.Message:
+			kc.setBrokersDown(false, nil)
+
+			tp := e.TopicPartition
+			kc.offsets.track(tp)
+
+			pending = append(pending, &message{
+				value: e.Value,
+				ack: func() {
+					kc.acknowledge(tp)
+				},
+			})
+		case kafka.Error:
+			kc.logger.Error("Kafka error", zap.Error(e))
+			if e.Code() == kafka.ErrAllBrokersDown {
+				kc.setBrokersDown(true, e)
+			}
+		case kafka.OffsetsCommitted:
+			if e.Error == nil {
+				kc.setBrokersDown(false, nil)
+			}
+		case *kafka.Stats:
+			// A quiet input topic delivers no messages, the statistics tell when the brokers are back
+			if brokersUp(e) {
+				kc.setBrokersDown(false, nil)
+			}
+		}
+	}
+}
+
+// setBrokersDown reports a change of the availability of the brokers
+func (kc *kafkaConsumer) setBrokersDown(down bool, err error) {
+	if kc.brokersDown == down {
+		return
+	}
+
+	kc.brokersDown = down
+	if kc.onConnectionState != nil {
+		kc.onConnectionState(!down, err)
+	}
+}
+
+// deliver hands pending messages to the output channel without blocking and returns those that did not fit
+func (kc *kafkaConsumer) deliver(pending []*message) []*message {
+	for len(pending) > 0 {
+		select {
+		case kc.outputCh <- pending[0]:
+			pending = pending[1:]
+		default:
+			return pending
+		}
+	}
+
+	return pending
+}
+
+// setPaused pauses or resumes fetching from the assigned partitions
+func (kc *kafkaConsumer) setPaused(paused bool) {
+	partitions, err := kc.consumer.Assignment()
+	if err != nil {
+		kc.logger.Error("Failed to get assigned partitions", zap.Error(err))
+		return
+	}
+
+	if paused {
+		kc.logger.Warn("Output channel stays full, pausing consumer")
+		err = kc.consumer.Pause(partitions)
+	} else {
+		kc.logger.Info("Resuming consumer")
+		err = kc.consumer.Resume(partitions)
+	}
+
+	if err != nil {
+		kc.logger.Error("Failed to pause or resume consumer", zap.Bool("paused", paused), zap.Error(err))
+	}
+}
+
+// acknowledge marks a message as handled and stores the offset that became committable, if any
+func (kc *kafkaConsumer) acknowledge(tp kafka.TopicPartition) {
+	offset, ok := kc.offsets.ack(tp)
+	if !ok {
+		return
+	}
+
+	tp.Offset = offset
+	if _, err := kc.consumer.StoreOffsets([]kafka.TopicPartition{tp}); err != nil {
+		kc.logger.Warn("Failed to store offset", zap.String("topic", *tp.Topic), zap.Int32("partition", tp.Partition), zap.Error(err))
+	}
+}
+
+// Close commits the stored offsets and closes the consumer
+func (kc *kafkaConsumer) Close() {
+	kc.logger.Info("Closing Kafka consumer...")
+
+	if _, err := kc.consumer.Commit(); err != nil {
+		if kafkaErr, ok := err.(kafka.Error); !ok || kafkaErr.Code() != kafka.ErrNoOffset {
+			kc.logger.Warn("Failed to commit offsets", zap.Error(err))
+		}
+	}
+
+	if err := kc.consumer.Close(); err != nil {
+		kc.logger.Error("Failed to close Kafka consumer", zap.Error(err))
+	}
+}
@@ -0,0 +1,270 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/johandrevandeventer/dse-worker/internal/config"
+	"github.com/johandrevandeventer/dse-worker/internal/config/app"
+	"github.com/johandrevandeventer/dse-worker/internal/flags"
+	"github.com/johandrevandeventer/dse-worker/internal/workers"
+	coreutils "github.com/johandrevandeventer/dse-worker/utils"
+	"go.uber.org/zap"
+)
+
+// controlFile is a file that requests an action of the running engine when it appears
+type controlFile struct {
+	name   string
+	path   string
+	action func() error
+}
+
+// controlFiles returns the control files, in the order they are acted on when several appear at once
+func (e *Engine) controlFiles() []controlFile {
+	runtimeCfg := e.cfg.App.Runtime
+
+	return []controlFile{
+		{name: "pause", path: runtimeCfg.PauseFilePath, action: func() error { e.SetPaused(true); return nil }},
+		{name: "resume", path: runtimeCfg.ResumeFilePath, action: func() error { e.SetPaused(false); return nil }},
+		{name: "reload-config", path: runtimeCfg.ReloadConfigFilePath, action: e.ReloadConfig},
+		{name: "reload-ignored", path: runtimeCfg.ReloadIgnoredFilePath, action: e.ReloadIgnored},
+		{name: "flush-cache", path: runtimeCfg.CacheFlushFilePath, action: func() error { e.FlushCache(); return nil }},
+		{name: "dump-state", path: runtimeCfg.DumpStateFilePath, action: e.DumpState},
+	}
+}
+
+// WatchControlFiles acts on the control files that appear.
+// A control file is removed before its action runs, so that it is acted on once.
+func (e *Engine) WatchControlFiles() {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	controlFiles := e.controlFiles()
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+			for _, cf := range controlFiles {
+				if cf.path == "" {
+					continue
+				}
+
+				if _, err := os.Stat(cf.path); err != nil {
+					continue
+				}
+
+				if err := os.Remove(cf.path); err != nil {
+					e.logger.Error("Failed to remove control file", zap.String("action", cf.name), zap.Error(err))
+					continue
+				}
+
+				e.runControlAction(cf.name, cf.action)
+			}
+		}
+	}
+}
+
+// runControlAction runs the action of a control request and writes its outcome to the connections log
+func (e *Engine) runControlAction(name string, action func() error) error {
+	err := action()
+
+	now := time.Now().Format(time.RFC3339)
+	if err != nil {
+		e.logger.Error("Control action failed", zap.String("action", name), zap.Error(err))
+		coreutils.WriteToLogFile(e.connectionsLogFilePath, fmt.Sprintf("%s: Control %s failed: %v\n", now, name, err))
+		return err
+	}
+
+	e.logger.Info("Control action applied", zap.String("action", name))
+	coreutils.WriteToLogFile(e.connectionsLogFilePath, fmt.Sprintf("%s: Control %s applied\n", now, name))
+	return nil
+}
+
+// appConfig returns the current configuration, including the sections reloaded while running
+func (e *Engine) appConfig() *app.AppConfig {
+	return e.liveCfg.Load()
+}
+
+// SetPaused pauses or resumes the consumption of input messages. In-flight messages still finish.
+// It returns false if consumption already was in the requested state.
+func (e *Engine) SetPaused(paused bool) bool {
+	if e.paused.Swap(paused) == paused {
+		return false
+	}
+
+	// Wake the dispatcher to take or stop taking messages
+	select {
+	case e.pauseCh <- struct{}{}:
+	default:
+	}
+
+	if paused {
+		consumptionPaused.Set(1)
+		e.logger.Warn("Consumption paused")
+	} else {
+		consumptionPaused.Set(0)
+		e.logger.Info("Consumption resumed")
+	}
+
+	e.statePersister.Set("app.paused", paused)
+	return true
+}
+
+// ReloadConfig reads the app configuration again and applies the routes, error policies and dead-letter settings.
+// Other sections are only applied on restart, changes to them are logged.
+func (e *Engine) ReloadConfig() error {
+	current := e.appConfig()
+
+	reloaded, err := config.ReloadAppConfig()
+	if err != nil {
+		return fmt.Errorf("failed to reload configuration: %w", err)
+	}
+
+	// Sinks are not reloaded, so routes can only use the running sinks
+	for _, route := range reloaded.Routes {
+		if _, ok := e.sinks[routeSink(route)]; !ok {
+			return fmt.Errorf("route %s refers to unknown sink %s", route.Name, routeSink(route))
+		}
+	}
+
+	live := *current
+	live.Routes = reloaded.Routes
+	live.ErrorPolicies = reloaded.ErrorPolicies
+	live.DeadLetter = reloaded.DeadLetter
+	e.liveCfg.Store(&live)
+
+	if sections := restartSections(current, reloaded); len(sections) > 0 {
+		e.logger.Warn("Configuration changes that require a restart were not applied", zap.Strings("sections", sections))
+	}
+
+	return nil
+}
+
+// restartSections returns the configuration sections that changed and are only applied on restart
+func restartSections(current *app.AppConfig, reloaded *app.AppConfig) []string {
+	sections := []struct {
+		name              string
+		current, reloaded any
+	}{
+		{"runtime", current.Runtime, reloaded.Runtime},
+		{"logging", current.Logging, reloaded.Logging},
+		{"kafka", current.Kafka, reloaded.Kafka},
+		{"database", current.Database, reloaded.Database},
+		{"features", current.Features, reloaded.Features},
+		{"workers", current.Workers, reloaded.Workers},
+		{"cache", current.Cache, reloaded.Cache},
+		{"ignored", current.Ignored, reloaded.Ignored},
+		{"monitoring", current.Monitoring, reloaded.Monitoring},
+		{"admin", current.Admin, reloaded.Admin},
+		{"stats", current.Stats, reloaded.Stats},
+		{"connectivity", current.Connectivity, reloaded.Connectivity},
+		{"alarms", current.Alarms, reloaded.Alarms},
+		{"run_sessions", current.RunSessions, reloaded.RunSessions},
+		{"dedup", current.Dedup, reloaded.Dedup},
+		{"sinks", current.Sinks, reloaded.Sinks},
+	}
+
+	var changed []string
+	for _, section := range sections {
+		if !reflect.DeepEqual(section.current, section.reloaded) {
+			changed = append(changed, section.name)
+		}
+	}
+
+	return changed
+}
+
+// ReloadIgnored reloads the ignore list, whether or not the ignore file changed
+func (e *Engine) ReloadIgnored() error {
+	_, err := workers.ReloadIgnoredFile(true)
+	if err != nil {
+		return fmt.Errorf("failed to reload ignore list, keeping the last good list: %w", err)
+	}
+
+	return nil
+}
+
+// FlushCache empties the device cache and returns its statistics before the flush
+func (e *Engine) FlushCache() workers.CacheStats {
+	stats := workers.GetCacheStats()
+	workers.InvalidateCache()
+
+	e.logger.Info("Device cache flushed", zap.Any("cache", stats))
+	return stats
+}
+
+// StateSnapshot returns the runtime state of the engine
+func (e *Engine) StateSnapshot() map[string]any {
+	connections := make(map[string]connectionState)
+	for _, client := range []string{kafkaClientConsumer, kafkaClientProducer} {
+		if state, ok := e.connections.get(client); ok {
+			connections[client] = state
+		}
+	}
+
+	inFlight := 0
+	if e.kafkaConsumer != nil {
+		inFlight = e.kafkaConsumer.offsets.inFlight()
+	}
+
+	dedupEntries := 0
+	if e.dedup != nil {
+		dedupEntries = e.dedup.Len()
+	}
+
+	return map[string]any{
+		"time":           time.Now().Format(time.RFC3339),
+		"name":           e.cfg.System.AppName,
+		"version":        e.cfg.System.AppVersion,
+		"environment":    flags.FlagEnvironment,
+		"start_time":     startTime.Format(time.RFC3339),
+		"paused":         e.paused.Load(),
+		"kafka":          connections,
+		"in_flight":      inFlight,
+		"last_consumed":  formatUnixNano(e.health.lastConsumed.Load()),
+		"last_published": formatUnixNano(e.health.lastPublished.Load()),
+		"cache":          workers.GetCacheStats(),
+		"dedup_entries":  dedupEntries,
+	}
+}
+
+// DumpState writes the runtime state of the engine to the state dump file
+func (e *Engine) DumpState() error {
+	filePath := e.cfg.App.Runtime.StateDumpFilePath
+
+	data, err := json.MarshalIndent(e.StateSnapshot(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o770); err != nil {
+		return fmt.Errorf("failed to create state dump directory: %w", err)
+	}
+
+	// Write to a temporary file first, so that readers never see a partial dump
+	tmpFilePath := filePath + ".tmp"
+	if err := os.WriteFile(tmpFilePath, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write state dump: %w", err)
+	}
+
+	if err := os.Rename(tmpFilePath, filePath); err != nil {
+		return fmt.Errorf("failed to write state dump: %w", err)
+	}
+
+	e.logger.Info("State dumped", zap.String("path", filepath.ToSlash(filePath)))
+	return nil
+}
+
+// formatUnixNano formats a Unix nanosecond time, or returns an empty string if it was never set
+func formatUnixNano(ns int64) string {
+	if ns == 0 {
+		return ""
+	}
+
+	return time.Unix(0, ns).Format(time.RFC3339)
+}
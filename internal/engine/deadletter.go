@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/johandrevandeventer/dse-worker/internal/workers"
+	dseworker "github.com/johandrevandeventer/dse-worker/internal/workers/dse_worker"
+	"github.com/johandrevandeventer/dse-worker/internal/workers/types"
+	"github.com/johandrevandeventer/kafkaclient/payload"
+	"go.uber.org/zap"
+)
+
+// publishDeadLetter publishes a message the worker could not process to the dead-letter topic.
+// It returns an error only if the dead letter could not be published.
+func (e *Engine) publishDeadLetter(p payload.Payload, processingErr error, attempts int) error {
+	deadLetterCfg := e.appConfig().DeadLetter
+	if !deadLetterCfg.Enabled || deadLetterCfg.Topic == "" {
+		return nil
+	}
+
+	deadLetter := &types.DeadLetter{
+		Payload:   p,
+		Category:  workers.ErrorCategory(processingErr),
+		Error:     processingErr.Error(),
+		Worker:    dseworker.WorkerTitle,
+		Attempts:  attempts,
+		Timestamp: time.Now(),
+	}
+
+	var workerErr *workers.WorkerError
+	if errors.As(processingErr, &workerErr) {
+		deadLetter.Worker = workerErr.Worker
+		deadLetter.Decoder = workerErr.Decoder
+	}
+
+	serializedDeadLetter, err := json.Marshal(deadLetter)
+	if err != nil {
+		return fmt.Errorf("failed to serialize dead letter: %w", err)
+	}
+
+	dp := payload.Payload{
+		ID:               p.ID,
+		MqttTopic:        p.MqttTopic,
+		Message:          serializedDeadLetter,
+		MessageTimestamp: deadLetter.Timestamp,
+	}
+
+	serializedDp, err := dp.Serialize()
+	if err != nil {
+		return fmt.Errorf("failed to serialize dead letter payload: %w", err)
+	}
+
+	err = e.sendMessage(deadLetterCfg.Topic, serializedDp)
+	if err != nil {
+		return fmt.Errorf("failed to send dead letter to Kafka topic %s: %w", deadLetterCfg.Topic, err)
+	}
+
+	e.logger.Warn("Message sent to dead-letter topic", zap.String("id", p.ID.String()), zap.String("category", deadLetter.Category), zap.String("topic", deadLetterCfg.Topic))
+	return nil
+}
+
+// publishDeadLetterWithRetry publishes a dead letter, retrying with exponential backoff until it is published or the engine stops
+func (e *Engine) publishDeadLetterWithRetry(p payload.Payload, processingErr error, attempts int) error {
+	kafkaCfg := e.cfg.App.Kafka
+
+	retryBackoff := backoff.NewExponentialBackOff()
+	if kafkaCfg.ProducerRetryBackoffMs > 0 {
+		retryBackoff.InitialInterval = time.Duration(kafkaCfg.ProducerRetryBackoffMs) * time.Millisecond
+	}
+	if kafkaCfg.ProducerRetryMaxBackoffMs > 0 {
+		retryBackoff.MaxInterval = time.Duration(kafkaCfg.ProducerRetryMaxBackoffMs) * time.Millisecond
+	}
+	retryBackoff.MaxElapsedTime = 0 // Keep trying until the engine stops
+
+	return backoff.RetryNotify(func() error {
+		return e.publishDeadLetter(p, processingErr, attempts)
+	}, backoff.WithContext(retryBackoff, e.workCtx), func(err error, duration time.Duration) {
+		e.logger.Warn("Failed to publish dead letter, retrying...", zap.String("id", p.ID.String()), zap.Error(err), zap.Duration("retry_after", duration))
+	})
+}
@@ -0,0 +1,255 @@
+package engine
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/johandrevandeventer/dse-worker/internal/config/app"
+	"github.com/johandrevandeventer/dse-worker/internal/workers/types"
+	"go.uber.org/zap"
+)
+
+// Duplicate kinds
+const (
+	duplicateByPayloadID       = "payload_id"
+	duplicateByDeviceTimestamp = "device_timestamp"
+)
+
+// dedupEntry is a key in the deduplication window, with the time it was last published
+type dedupEntry struct {
+	Key  string    `json:"key"`
+	Seen time.Time `json:"seen"`
+}
+
+// dedupWindow remembers the keys of published messages for a limited time and number of entries
+type dedupWindow struct {
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	order      *list.List // Oldest entry first
+	maxEntries int
+	ttl        time.Duration
+	filePath   string
+	dirty      bool
+}
+
+func newDedupWindow(cfg app.DedupConfig) *dedupWindow {
+	return &dedupWindow{
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		maxEntries: max(cfg.MaxEntries, 1),
+		ttl:        time.Duration(cfg.TTLSeconds) * time.Second,
+		filePath:   cfg.FilePath,
+	}
+}
+
+// Contains reports whether a key is in the window and has not expired
+func (w *dedupWindow) Contains(key string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	el, ok := w.entries[key]
+	if !ok {
+		return false
+	}
+
+	return !w.expired(el.Value.(*dedupEntry), time.Now())
+}
+
+// Add adds keys to the window, or refreshes them if they are already in it
+func (w *dedupWindow) Add(keys ...string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	for _, key := range keys {
+		w.add(&dedupEntry{Key: key, Seen: now})
+	}
+
+	w.evict(now)
+	w.dirty = true
+}
+
+// Len returns the number of entries in the window
+func (w *dedupWindow) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.order.Len()
+}
+
+func (w *dedupWindow) add(entry *dedupEntry) {
+	if el, ok := w.entries[entry.Key]; ok {
+		w.order.Remove(el)
+	}
+
+	w.entries[entry.Key] = w.order.PushBack(entry)
+}
+
+func (w *dedupWindow) expired(entry *dedupEntry, now time.Time) bool {
+	return w.ttl > 0 && now.Sub(entry.Seen) > w.ttl
+}
+
+// evict removes expired entries, then the oldest entries beyond the window size
+func (w *dedupWindow) evict(now time.Time) {
+	for el := w.order.Front(); el != nil; el = w.order.Front() {
+		entry := el.Value.(*dedupEntry)
+		if !w.expired(entry, now) && w.order.Len() <= w.maxEntries {
+			return
+		}
+
+		w.order.Remove(el)
+		delete(w.entries, entry.Key)
+	}
+}
+
+// Load restores the window from its store. A missing store is not an error.
+func (w *dedupWindow) Load() error {
+	data, err := os.ReadFile(w.filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var entries []*dedupEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("invalid deduplication store %s: %w", w.filePath, err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, entry := range entries {
+		w.add(entry)
+	}
+
+	w.evict(time.Now())
+	return nil
+}
+
+// Save writes the window to its store if it changed since the last save
+func (w *dedupWindow) Save() error {
+	w.mu.Lock()
+	if !w.dirty {
+		w.mu.Unlock()
+		return nil
+	}
+
+	w.evict(time.Now())
+	entries := make([]*dedupEntry, 0, w.order.Len())
+	for el := w.order.Front(); el != nil; el = el.Next() {
+		entries = append(entries, el.Value.(*dedupEntry))
+	}
+	w.dirty = false
+	w.mu.Unlock()
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(w.filePath), 0o770); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so a crash never leaves a truncated store
+	tmpFilePath := w.filePath + ".tmp"
+	if err := os.WriteFile(tmpFilePath, data, 0o660); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFilePath, w.filePath)
+}
+
+// payloadIDKey returns the deduplication key of a payload ID, or false for a payload without ID
+func payloadIDKey(id uuid.UUID) (string, bool) {
+	if id == uuid.Nil {
+		return "", false
+	}
+
+	return "id:" + id.String(), true
+}
+
+// deviceTimestampKey returns the deduplication key of the record of a device at a point in time
+func deviceTimestampKey(device types.Device) string {
+	return fmt.Sprintf("device:%s/%s@%s", device.Controller, device.DeviceIdentifier, device.Timestamp.UTC().Format(time.RFC3339Nano))
+}
+
+// isDuplicatePayload reports whether a payload ID has already been published
+func (e *Engine) isDuplicatePayload(id uuid.UUID) bool {
+	if e.dedup == nil {
+		return false
+	}
+
+	key, ok := payloadIDKey(id)
+	if !ok || !e.dedup.Contains(key) {
+		return false
+	}
+
+	duplicatesSkipped.WithLabelValues(duplicateByPayloadID).Inc()
+	return true
+}
+
+// filterDuplicateDevices removes the device records whose timestamp has already been published
+func (e *Engine) filterDuplicateDevices(devices []types.Device) []types.Device {
+	if e.dedup == nil || !e.cfg.App.Dedup.ByDeviceTimestamp {
+		return devices
+	}
+
+	filtered := make([]types.Device, 0, len(devices))
+	for _, device := range devices {
+		if e.dedup.Contains(deviceTimestampKey(device)) {
+			duplicatesSkipped.WithLabelValues(duplicateByDeviceTimestamp).Inc()
+			continue
+		}
+
+		filtered = append(filtered, device)
+	}
+
+	return filtered
+}
+
+// rememberPublished adds a published message to the deduplication window
+func (e *Engine) rememberPublished(id uuid.UUID, devices []types.Device) {
+	if e.dedup == nil {
+		return
+	}
+
+	var keys []string
+	if key, ok := payloadIDKey(id); ok {
+		keys = append(keys, key)
+	}
+
+	if e.cfg.App.Dedup.ByDeviceTimestamp {
+		for _, device := range devices {
+			keys = append(keys, deviceTimestampKey(device))
+		}
+	}
+
+	e.dedup.Add(keys...)
+}
+
+// WatchDedupWindow saves the deduplication window periodically
+func (e *Engine) WatchDedupWindow() {
+	interval := time.Duration(max(e.cfg.App.Dedup.FlushIntervalSeconds, 1)) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.dedup.Save(); err != nil {
+				e.logger.Error("Failed to save deduplication window", zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/johandrevandeventer/dse-worker/internal/config/app"
+	"github.com/johandrevandeventer/dse-worker/internal/workers/types"
+)
+
+func TestDedupWindowEvictsBySize(t *testing.T) {
+	w := newDedupWindow(app.DedupConfig{MaxEntries: 2, TTLSeconds: 3600})
+
+	w.Add("a", "b", "c")
+	if w.Contains("a") {
+		t.Error("oldest entry a kept beyond the window size")
+	}
+	if !w.Contains("b") || !w.Contains("c") {
+		t.Error("newest entries b and c evicted")
+	}
+
+	// Refreshing b makes c the oldest entry
+	w.Add("b")
+	w.Add("d")
+	if w.Contains("c") {
+		t.Error("entry c kept after b was refreshed and d added")
+	}
+	if !w.Contains("b") || !w.Contains("d") {
+		t.Error("refreshed entry b or new entry d evicted")
+	}
+
+	if got := w.Len(); got != 2 {
+		t.Errorf("Len() = %d, want 2", got)
+	}
+}
+
+func TestDedupWindowExpiresByTTL(t *testing.T) {
+	w := newDedupWindow(app.DedupConfig{MaxEntries: 10, TTLSeconds: 60})
+
+	now := time.Now()
+	w.add(&dedupEntry{Key: "old", Seen: now.Add(-2 * time.Minute)})
+	w.add(&dedupEntry{Key: "recent", Seen: now.Add(-30 * time.Second)})
+
+	if w.Contains("old") {
+		t.Error("expired entry reported as contained")
+	}
+	if !w.Contains("recent") {
+		t.Error("entry within the TTL not contained")
+	}
+
+	// Adding evicts the expired entries
+	w.Add("new")
+	if got := w.Len(); got != 2 {
+		t.Errorf("Len() = %d after eviction, want 2", got)
+	}
+}
+
+func TestDedupWindowWithoutTTL(t *testing.T) {
+	w := newDedupWindow(app.DedupConfig{MaxEntries: 10})
+
+	w.add(&dedupEntry{Key: "old", Seen: time.Now().Add(-24 * time.Hour)})
+	if !w.Contains("old") {
+		t.Error("entry expired without a TTL")
+	}
+}
+
+func TestDedupWindowSaveLoad(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "persist", "dedup.json")
+	cfg := app.DedupConfig{MaxEntries: 10, TTLSeconds: 60, FilePath: filePath}
+
+	// A missing store is an empty window
+	w := newDedupWindow(cfg)
+	if err := w.Load(); err != nil {
+		t.Fatalf("Load() of a missing store: %v", err)
+	}
+
+	// An unchanged window is not written
+	if err := w.Save(); err != nil {
+		t.Fatalf("Save(): %v", err)
+	}
+	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+		t.Errorf("unchanged window written to its store")
+	}
+
+	w.add(&dedupEntry{Key: "expired", Seen: time.Now().Add(-2 * time.Minute)})
+	w.Add("a", "b")
+	if err := w.Save(); err != nil {
+		t.Fatalf("Save(): %v", err)
+	}
+
+	loaded := newDedupWindow(cfg)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("Load(): %v", err)
+	}
+
+	if !loaded.Contains("a") || !loaded.Contains("b") {
+		t.Error("saved entries not loaded")
+	}
+	if got := loaded.Len(); got != 2 {
+		t.Errorf("Len() = %d after load, want 2 without the expired entry", got)
+	}
+
+	// Loading into a smaller window keeps the newest entries
+	small := newDedupWindow(app.DedupConfig{MaxEntries: 1, TTLSeconds: 60, FilePath: filePath})
+	if err := small.Load(); err != nil {
+		t.Fatalf("Load(): %v", err)
+	}
+	if small.Contains("a") || !small.Contains("b") {
+		t.Error("smaller window did not keep only the newest entry")
+	}
+
+	if err := os.WriteFile(filePath, []byte("not json"), 0o660); err != nil {
+		t.Fatal(err)
+	}
+	if err := newDedupWindow(cfg).Load(); err == nil {
+		t.Error("Load() of an invalid store succeeded")
+	}
+}
+
+func newDedupTestEngine(t *testing.T, byDeviceTimestamp bool) *Engine {
+	dedupCfg := app.DedupConfig{Enabled: true, ByDeviceTimestamp: byDeviceTimestamp, MaxEntries: 100, TTLSeconds: 3600}
+
+	e := newTestEngine(t, app.AppConfig{Dedup: dedupCfg})
+	e.dedup = newDedupWindow(dedupCfg)
+
+	return e
+}
+
+func TestFilterDuplicateDevices(t *testing.T) {
+	timestamp := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	published := []types.Device{
+		{Controller: "DSE890", DeviceIdentifier: "genset-1", Timestamp: timestamp},
+		{Controller: "DSE890", DeviceIdentifier: "genset-2", Timestamp: timestamp},
+	}
+	next := []types.Device{
+		{Controller: "DSE890", DeviceIdentifier: "genset-1", Timestamp: timestamp},
+		{Controller: "DSE890", DeviceIdentifier: "genset-2", Timestamp: timestamp.Add(time.Minute)},
+		{Controller: "DSE890", DeviceIdentifier: "genset-3", Timestamp: timestamp},
+	}
+
+	t.Run("by device timestamp", func(t *testing.T) {
+		e := newDedupTestEngine(t, true)
+		e.rememberPublished(uuid.New(), published)
+
+		filtered := e.filterDuplicateDevices(next)
+		if len(filtered) != 2 || filtered[0].DeviceIdentifier != "genset-2" || filtered[1].DeviceIdentifier != "genset-3" {
+			t.Errorf("filterDuplicateDevices() = %v, want genset-2 and genset-3", filtered)
+		}
+	})
+
+	t.Run("by payload ID only", func(t *testing.T) {
+		e := newDedupTestEngine(t, false)
+		e.rememberPublished(uuid.New(), published)
+
+		if filtered := e.filterDuplicateDevices(next); len(filtered) != len(next) {
+			t.Errorf("filterDuplicateDevices() = %v, want all devices", filtered)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		e := newTestEngine(t, app.AppConfig{})
+		e.rememberPublished(uuid.New(), published)
+
+		if filtered := e.filterDuplicateDevices(next); len(filtered) != len(next) {
+			t.Errorf("filterDuplicateDevices() = %v, want all devices", filtered)
+		}
+	})
+}
+
+func TestIsDuplicatePayload(t *testing.T) {
+	e := newDedupTestEngine(t, false)
+
+	id := uuid.New()
+	if e.isDuplicatePayload(id) {
+		t.Error("unpublished payload reported as duplicate")
+	}
+
+	e.rememberPublished(id, nil)
+	if !e.isDuplicatePayload(id) {
+		t.Error("published payload not reported as duplicate")
+	}
+
+	// Payloads without ID are never duplicates
+	e.rememberPublished(uuid.Nil, nil)
+	if e.isDuplicatePayload(uuid.Nil) {
+		t.Error("payload without ID reported as duplicate")
+	}
+}
@@ -6,17 +6,21 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/johandrevandeventer/dse-worker/internal/config"
+	"github.com/johandrevandeventer/dse-worker/internal/config/app"
 	"github.com/johandrevandeventer/dse-worker/internal/flags"
+	"github.com/johandrevandeventer/dse-worker/internal/workers"
 	coreutils "github.com/johandrevandeventer/dse-worker/utils"
-	"github.com/johandrevandeventer/kafkaclient/consumer"
-	"github.com/johandrevandeventer/kafkaclient/producer"
 	"github.com/johandrevandeventer/persist"
 	"go.uber.org/zap"
 )
 
+// cacheSweepInterval is how often expired devices are removed from the device cache
+const cacheSweepInterval = time.Minute
+
 var (
 	startTime time.Time
 	endTime   time.Time
@@ -25,7 +29,10 @@ var (
 type Engine struct {
 	ctx                      context.Context
 	cancelFunc               context.CancelFunc
+	workCtx                  context.Context // Outlives ctx while in-flight messages are drained
+	workCancelFunc           context.CancelFunc
 	cfg                      *config.Config
+	liveCfg                  atomic.Pointer[app.AppConfig] // Reloadable sections of the configuration
 	logger                   *zap.Logger
 	statePersister           *persist.FilePersister
 	stopFileChan             chan struct{}
@@ -34,26 +41,55 @@ type Engine struct {
 	stopFileFilePath         string
 	connectionsLogFilePath   string
 	wg                       sync.WaitGroup
-	kafkaProducerPool        *producer.KafkaProducerPool
-	kafkaConsumer            *consumer.KafkaConsumer
+	shardsMu                 sync.Mutex // Orders starting the shards with the drain waiting for them
+	shardsWg                 sync.WaitGroup
+	kafkaProducerPool        atomic.Pointer[kafkaProducerPool] // Set by the producer supervisor, read by the shards
+	kafkaConsumer            *kafkaConsumer
+	inputCh                  chan *message
+	health                   *healthState
+	dedup                    *dedupWindow
+	sinks                    map[string]OutputSink
+	connections              *connectionStates
+	devices                  *deviceTracker
+	stats                    *runtimeStats
+	connectivity             *connectivityTracker
+	alarms                   *alarmTracker
+	runSessions              *runSessionTracker
+	paused                   atomic.Bool
+	pauseCh                  chan struct{} // Signals the dispatcher that paused changed
 }
 
 // NewEngine creates a new Engine instance
 func NewEngine(ctx context.Context, cfg *config.Config, logger *zap.Logger, statePersister *persist.FilePersister) *Engine {
 	ctx, cancel := context.WithCancel(ctx)
+	workCtx, workCancel := context.WithCancel(context.Background())
 
-	return &Engine{
+	e := &Engine{
 		ctx:                      ctx,
 		cancelFunc:               cancel,
+		workCtx:                  workCtx,
+		workCancelFunc:           workCancel,
 		cfg:                      cfg,
 		logger:                   logger,
 		statePersister:           statePersister,
 		stopFileChan:             make(chan struct{}),
 		kafkaConsumerConnectedCh: make(chan struct{}),
+		inputCh:                  make(chan *message, max(cfg.App.Workers.QueueDepth, 1)),
+		health:                   newHealthState(),
+		connections:              newConnectionStates(),
+		devices:                  newDeviceTracker(),
+		stats:                    newRuntimeStats(),
+		connectivity:             newConnectivityTracker(),
+		alarms:                   newAlarmTracker(),
+		runSessions:              newRunSessionTracker(),
 		tmpFilePath:              cfg.App.Runtime.TmpDir,
 		stopFileFilePath:         cfg.App.Runtime.StopFileFilepath,
 		connectionsLogFilePath:   cfg.App.Runtime.ConnectionsLogFilePath,
+		pauseCh:                  make(chan struct{}, 1),
 	}
+	e.liveCfg.Store(cfg.App)
+
+	return e
 }
 
 // Run starts the Engine
@@ -72,6 +108,50 @@ func (e *Engine) Run() {
 	connectionsLogFilePathDir := filepath.Dir(e.connectionsLogFilePath)
 	e.verboseDebug("Creating connections directory", zap.String("path", filepath.ToSlash(connectionsLogFilePathDir)))
 
+	// Load the ignore list
+	e.verboseDebug("Loading ignore list", zap.String("path", filepath.ToSlash(e.cfg.App.Ignored.FilePath)))
+	err = workers.LoadIgnoredFile(e.cfg.App.Ignored.FilePath)
+	if err != nil {
+		e.logger.Error("Failed to load ignore list", zap.Error(err))
+		return
+	}
+
+	// Configure the device cache
+	workers.ConfigureCache(
+		time.Duration(e.cfg.App.Cache.TTLSeconds)*time.Second,
+		time.Duration(e.cfg.App.Cache.NegativeTTLSeconds)*time.Second,
+	)
+
+	// Create the output sinks
+	err = e.startSinks()
+	if err != nil {
+		e.logger.Error("Failed to create output sinks", zap.Error(err))
+		e.closeSinks()
+		return
+	}
+
+	// Restore the deduplication window
+	if e.cfg.App.Dedup.Enabled {
+		e.dedup = newDedupWindow(e.cfg.App.Dedup)
+		err = e.dedup.Load()
+		if err != nil {
+			e.logger.Error("Failed to restore deduplication window, starting with an empty window", zap.Error(err))
+		}
+		e.verboseDebug("Deduplication window restored", zap.Int("entries", e.dedup.Len()))
+	}
+
+	// Continue the statistics, controller connectivity, alarms and run sessions of the previous run, before the app state is reset
+	e.restoreStats()
+	if e.cfg.App.Connectivity.Enabled {
+		e.restoreConnectivity()
+	}
+	if e.cfg.App.Alarms.Enabled {
+		e.restoreAlarms()
+	}
+	if e.cfg.App.RunSessions.Enabled {
+		e.restoreRunSessions()
+	}
+
 	startTime = time.Now()
 
 	// Set initial state
@@ -82,6 +162,17 @@ func (e *Engine) Run() {
 	e.statePersister.Set("app.release_date", e.cfg.System.ReleaseDate)
 	e.statePersister.Set("app.environment", flags.FlagEnvironment)
 	e.statePersister.Set("app.start_time", startTime.Format(time.RFC3339))
+	e.statePersister.Set("app.paused", false)
+	e.saveStats()
+	if e.cfg.App.Connectivity.Enabled {
+		e.saveConnectivity()
+	}
+	if e.cfg.App.Alarms.Enabled {
+		e.saveAlarms()
+	}
+	if e.cfg.App.RunSessions.Enabled {
+		e.saveRunSessions()
+	}
 
 	coreutils.WriteToLogFile(e.connectionsLogFilePath, fmt.Sprintf("%s: App started\n", startTime.Format(time.RFC3339)))
 
@@ -111,6 +202,79 @@ func (e *Engine) start() {
 		e.WatchStopFile(e.stopFileFilePath)
 	}()
 
+	// Watch for changes to the ignore file
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.WatchIgnoredFile()
+	}()
+
+	// Watch for control files
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.WatchControlFiles()
+	}()
+
+	// Save the deduplication window periodically
+	if e.dedup != nil {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			e.WatchDedupWindow()
+		}()
+	}
+
+	// Remove expired devices from the device cache
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.WatchDeviceCache()
+	}()
+
+	// Save the runtime statistics periodically
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.WatchRuntimeStats()
+	}()
+
+	// Report controllers going offline and coming back
+	if e.cfg.App.Connectivity.Enabled {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			e.WatchConnectivity()
+		}()
+	}
+
+	// End the run sessions of gensets that go quiet
+	if e.cfg.App.RunSessions.Enabled {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			e.WatchRunSessions()
+		}()
+	}
+
+	// Serve the admin API
+	if e.cfg.App.Admin.Enabled {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			e.startAdminServer()
+		}()
+	}
+
+	// Serve the monitoring endpoints
+	if e.cfg.App.Monitoring.Enabled {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			e.startMonitoringServer()
+		}()
+	}
+
 	e.wg.Add(1)
 	go func() {
 		defer e.wg.Done()
@@ -166,15 +330,22 @@ func (e *Engine) cleanup() {
 
 	// Close Kafka producer pool
 	e.verboseDebug("Closing Kafka producer pool")
-	if e.kafkaProducerPool != nil {
-		e.kafkaProducerPool.Close()
+	if kafkaProducerPool := e.kafkaProducerPool.Load(); kafkaProducerPool != nil {
+		kafkaProducerPool.Close()
+		kafkaProducerPoolSize.Set(0)
 	}
 	e.verboseDebug("Kafka producer pool closed")
 
-	// Close Kafka consumer
+	// Close output sinks
+	e.verboseDebug("Closing output sinks")
+	e.closeSinks()
+	e.verboseDebug("Output sinks closed")
+
+	// Close Kafka consumer, committing the offsets of the acknowledged messages
 	e.verboseDebug("Closing Kafka consumer")
 	if e.kafkaConsumer != nil {
 		e.kafkaConsumer.Close()
+		kafkaConsumersRunning.Dec()
 	}
 	e.verboseDebug("Kafka consumer closed")
 }
@@ -183,17 +354,38 @@ func (e *Engine) cleanup() {
 func (e *Engine) Stop() {
 	e.logger.Debug("Stopping application")
 
-	// Cancel the context to signal all goroutines to stop
+	// Cancel the context to signal all goroutines to stop, which stops consuming
 	if e.cancelFunc != nil {
 		e.cancelFunc()
 	}
 
+	// Let the in-flight messages finish
+	e.drain()
+
 	// Wait for all goroutines to finish
 	e.wg.Wait()
 
 	// Perform cleanup
 	e.cleanup()
 
+	// Save the deduplication window
+	if e.dedup != nil {
+		if err := e.dedup.Save(); err != nil {
+			e.logger.Error("Failed to save deduplication window", zap.Error(err))
+		}
+	}
+
+	e.logger.Info("Device cache statistics", zap.Any("cache", workers.GetCacheStats()))
+
+	// Save the final runtime statistics, controller connectivity and open run sessions
+	e.saveStats()
+	if e.cfg.App.Connectivity.Enabled {
+		e.saveConnectivity()
+	}
+	if e.cfg.App.RunSessions.Enabled {
+		e.saveRunSessions()
+	}
+
 	endTime = time.Now()
 	duration := endTime.Sub(startTime)
 
@@ -207,6 +399,54 @@ func (e *Engine) Stop() {
 	e.logger.Info("Application stopped")
 }
 
+// drain waits for the shards to finish their in-flight messages, up to the drain timeout.
+// Messages that do not finish in time are left uncommitted, to be redelivered. The outcome is persisted under app.drain.
+func (e *Engine) drain() {
+	timeout := time.Duration(e.cfg.App.Workers.DrainTimeoutSeconds) * time.Second
+	e.logger.Info("Draining in-flight messages", zap.Duration("timeout", timeout))
+
+	// The context is cancelled, so no shards start once the drain holds the lock
+	e.shardsMu.Lock()
+	e.shardsMu.Unlock()
+
+	drainStart := time.Now()
+	drained := make(chan struct{})
+	go func() {
+		e.shardsWg.Wait()
+		close(drained)
+	}()
+
+	status := "completed"
+	select {
+	case <-drained:
+	case <-time.After(timeout):
+		status = "timed_out"
+	}
+
+	// Abort the sends still in progress
+	e.workCancelFunc()
+	<-drained
+
+	duration := time.Since(drainStart)
+	unacknowledged := 0
+	if e.kafkaConsumer != nil {
+		unacknowledged = e.kafkaConsumer.offsets.inFlight()
+	}
+
+	if status == "completed" {
+		e.logger.Info("Drain completed", zap.Duration("duration", duration), zap.Int("unacknowledged", unacknowledged))
+	} else {
+		e.logger.Warn("Drain timed out, unfinished messages will be redelivered", zap.Duration("duration", duration), zap.Int("unacknowledged", unacknowledged))
+	}
+
+	e.statePersister.Set("app.drain", map[string]any{
+		"status":         status,
+		"duration":       duration.String(),
+		"unacknowledged": unacknowledged,
+		"time":           time.Now().Format(time.RFC3339),
+	})
+}
+
 // WatchStopFile watches for the presence of a stop file
 func (e *Engine) WatchStopFile(stopFileFilePath string) {
 	ticker := time.NewTicker(1 * time.Second)
@@ -231,6 +471,47 @@ func (e *Engine) WatchStopFile(stopFileFilePath string) {
 	}
 }
 
+// WatchIgnoredFile reloads the ignore list whenever the ignore file changes
+func (e *Engine) WatchIgnoredFile() {
+	interval := time.Duration(max(e.cfg.App.Ignored.ReloadIntervalSeconds, 1)) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := workers.ReloadIgnoredFile(false)
+			if err != nil {
+				e.logger.Error("Failed to reload ignore list, keeping the last good list", zap.Error(err))
+				continue
+			}
+
+			if reloaded {
+				e.logger.Info("Ignore list reloaded", zap.String("path", filepath.ToSlash(workers.IgnoredFilePath())))
+			}
+		}
+	}
+}
+
+// WatchDeviceCache periodically removes the expired devices from the device cache
+func (e *Engine) WatchDeviceCache() {
+	ticker := time.NewTicker(cacheSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+			if removed := workers.SweepCache(); removed > 0 {
+				e.verboseDebug("Expired devices removed from the cache", zap.Int("devices", removed))
+			}
+		}
+	}
+}
+
 // StopFileDetected returns a channel that is closed when the stop file is detected
 func (e *Engine) StopFileDetected() <-chan struct{} {
 	return e.stopFileChan
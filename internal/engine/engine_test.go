@@ -0,0 +1,36 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/johandrevandeventer/dse-worker/internal/config"
+	"github.com/johandrevandeventer/dse-worker/internal/config/app"
+	"github.com/johandrevandeventer/kafkaclient/payload"
+	"go.uber.org/zap"
+)
+
+// newTestEngine creates an engine with an app configuration, without starting it
+func newTestEngine(t *testing.T, appCfg app.AppConfig) *Engine {
+	t.Helper()
+
+	e := NewEngine(context.Background(), &config.Config{App: &appCfg}, zap.NewNop(), nil)
+	t.Cleanup(e.cancelFunc)
+
+	return e
+}
+
+// decodeRecord decodes the event of a record published by the engine
+func decodeRecord(t *testing.T, record Record, event any) {
+	t.Helper()
+
+	p, err := payload.Deserialize(record.Value)
+	if err != nil {
+		t.Fatalf("invalid record payload: %v", err)
+	}
+
+	if err := json.Unmarshal(p.Message, event); err != nil {
+		t.Fatalf("invalid event %s: %v", p.Message, err)
+	}
+}
@@ -0,0 +1,41 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	coreutils "github.com/johandrevandeventer/dse-worker/utils"
+	"github.com/johandrevandeventer/kafkaclient/payload"
+)
+
+// publishEvent publishes an event to a topic of an output sink
+func (e *Engine) publishEvent(sink string, topic string, event any, timestamp time.Time) error {
+	record, err := eventRecord(sink, topic, event, timestamp)
+	if err != nil {
+		return err
+	}
+
+	return e.sendRecords([]Record{record}, nil)
+}
+
+// eventRecord returns the record of an event, wrapped in a payload like the device data
+func eventRecord(sink string, topic string, event any, timestamp time.Time) (Record, error) {
+	serializedEvent, err := json.Marshal(event)
+	if err != nil {
+		return Record{}, fmt.Errorf("failed to serialize event: %w", err)
+	}
+
+	p := payload.Payload{
+		ID:               coreutils.GenerateUUID(),
+		Message:          serializedEvent,
+		MessageTimestamp: timestamp,
+	}
+
+	serializedPayload, err := p.Serialize()
+	if err != nil {
+		return Record{}, fmt.Errorf("failed to serialize event payload: %w", err)
+	}
+
+	return Record{Sink: sink, Topic: topic, Value: serializedPayload}, nil
+}
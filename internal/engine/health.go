@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/johandrevandeventer/devicesdb"
+)
+
+// heartbeatInterval is how often idle worker loops report that they are alive
+const heartbeatInterval = 5 * time.Second
+
+// healthState tracks the signals behind the health and readiness endpoints
+type healthState struct {
+	producerReady atomic.Bool
+	lastConsumed  atomic.Int64 // Unix nanoseconds
+	lastPublished atomic.Int64 // Unix nanoseconds
+	kafkaResumed  atomic.Int64 // Unix nanoseconds, when Kafka clients last stopped waiting
+
+	mu         sync.Mutex
+	heartbeats map[string]time.Time // Last progress of each worker loop
+}
+
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+func newHealthState() *healthState {
+	return &healthState{
+		heartbeats: make(map[string]time.Time),
+	}
+}
+
+// heartbeat records progress of a worker loop
+func (h *healthState) heartbeat(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.heartbeats[name] = time.Now()
+}
+
+// stalledLoops returns the worker loops that made no progress within the timeout.
+// Loops blocked while Kafka was waiting are given the timeout again from when it resumed.
+func (h *healthState) stalledLoops(timeout time.Duration) []string {
+	resumed := time.Unix(0, h.kafkaResumed.Load())
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var stalled []string
+	for name, last := range h.heartbeats {
+		if last.Before(resumed) {
+			last = resumed
+		}
+		if time.Since(last) > timeout {
+			stalled = append(stalled, name)
+		}
+	}
+
+	return stalled
+}
+
+// handleHealthz reports whether the worker loops are still making progress
+func (e *Engine) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	timeout := time.Duration(max(e.cfg.App.Monitoring.LivenessTimeoutSeconds, 1)) * time.Second
+
+	response := healthResponse{Status: "ok", Checks: map[string]string{}}
+
+	// While Kafka is down the worker loops block on publish retries and full queues.
+	// That is reported by /readyz, restarting the worker would not bring Kafka back.
+	if e.connections.waiting() {
+		writeHealthResponse(w, response)
+		return
+	}
+
+	for _, name := range e.health.stalledLoops(timeout) {
+		response.Status = "unavailable"
+		response.Checks[name] = "stalled"
+	}
+
+	writeHealthResponse(w, response)
+}
+
+// handleReadyz reports whether the worker is connected to its dependencies and publishing
+func (e *Engine) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	response := healthResponse{Status: "ok", Checks: map[string]string{}}
+
+	fail := func(check string, reason string) {
+		response.Status = "unavailable"
+		response.Checks[check] = reason
+	}
+
+	select {
+	case <-e.kafkaConsumerConnectedCh:
+		response.Checks["kafka_consumer"] = "ok"
+	default:
+		fail("kafka_consumer", "not connected")
+	}
+
+	if e.health.producerReady.Load() {
+		response.Checks["kafka_producer"] = "ok"
+	} else {
+		fail("kafka_producer", "not connected")
+	}
+
+	// A connected client loses readiness while its brokers are down
+	for _, client := range []string{kafkaClientConsumer, kafkaClientProducer} {
+		if state, ok := e.connections.get(client); ok && state.State == connectionStateWaiting {
+			fail("kafka_"+client, "waiting for Kafka: "+state.LastError)
+		}
+	}
+
+	if bmsDB, err := devicesdb.GetDB(); err != nil {
+		fail("devicesdb", err.Error())
+	} else if err := bmsDB.HealthCheck(); err != nil {
+		fail("devicesdb", err.Error())
+	} else {
+		response.Checks["devicesdb"] = "ok"
+	}
+
+	// Publishing is stale when messages were consumed since the last publish and it is too long ago
+	response.Checks["publish"] = "ok"
+	if maxAge := e.cfg.App.Monitoring.PublishMaxAgeSeconds; maxAge > 0 {
+		lastPublished := time.Unix(0, e.health.lastPublished.Load())
+		if lastPublished.Before(startTime) {
+			lastPublished = startTime
+		}
+
+		lastConsumed := time.Unix(0, e.health.lastConsumed.Load())
+		if lastConsumed.After(lastPublished) && time.Since(lastPublished) > time.Duration(maxAge)*time.Second {
+			fail("publish", "no successful publish since "+lastPublished.Format(time.RFC3339))
+		}
+	}
+
+	writeHealthResponse(w, response)
+}
+
+// writeHealthResponse writes a health response, with status 503 when a check failed
+func writeHealthResponse(w http.ResponseWriter, response healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	if response.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	_ = json.NewEncoder(w).Encode(response)
+}
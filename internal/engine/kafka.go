@@ -1,68 +1,175 @@
 package engine
 
 import (
-	"log"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
 
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/johandrevandeventer/dse-worker/internal/config/app"
 	"github.com/johandrevandeventer/dse-worker/internal/flags"
-	"github.com/johandrevandeventer/kafkaclient/config"
-	"github.com/johandrevandeventer/kafkaclient/consumer"
-	"github.com/johandrevandeventer/kafkaclient/producer"
 	"github.com/johandrevandeventer/logging"
 	"go.uber.org/zap"
 )
 
+const (
+	// defaultKafkaConnectTimeout is the time a Kafka client waits for the brokers when it connects, if not configured
+	defaultKafkaConnectTimeout = 10 * time.Second
+
+	// kafkaStatisticsInterval is how often the Kafka clients report statistics, from which they notice the brokers are back
+	kafkaStatisticsInterval = 5 * time.Second
+)
+
 func (e *Engine) startKafkaProducer() {
 	e.logger.Info("Starting Kafka producer")
 
 	var kafkaProducerLogger *zap.Logger
-	if flags.FlagKafkaLogging {
+	if flags.FlagKafkaLogging || e.cfg.App.Features.KafkaLogging {
 		kafkaProducerLogger = logging.GetLogger("kafka.producer")
 	} else {
 		kafkaProducerLogger = zap.NewNop()
 	}
 
-	// Define Kafka producer config
-	producerConfig := config.NewKafkaProducerConfig("localhost:9092", 5, 5)
+	kafkaCfg := e.cfg.App.Kafka
+	if kafkaCfg.PublishMode == app.PublishModeTransactional && kafkaCfg.TransactionalID == "" {
+		hostname, _ := os.Hostname()
+		kafkaCfg.TransactionalID = fmt.Sprintf("%s-%s", kafkaCfg.ConsumerGroup, hostname)
+	}
 
-	// Initialize Kafka Producer Pool
-	kafkaProducerPool, err := producer.NewKafkaProducerPool(e.ctx, producerConfig, kafkaProducerLogger)
-	if err != nil {
-		log.Fatalf("Failed to create Kafka producer pool: %v", err)
+	// Initialize Kafka Producer Pool, waiting for Kafka while it is unavailable
+	connected := e.superviseKafka(kafkaClientProducer, func() error {
+		kafkaProducerPool, err := newKafkaProducerPool(e.workCtx, kafkaCfg, kafkaProducerLogger)
+		if err != nil {
+			return err
+		}
+
+		kafkaProducerPool.onConnectionState = func(connected bool, err error) {
+			e.setKafkaConnected(kafkaClientProducer, connected, err)
+		}
+
+		e.kafkaProducerPool.Store(kafkaProducerPool)
+		return nil
+	})
+	if !connected {
+		return
 	}
 
-	e.kafkaProducerPool = kafkaProducerPool
+	e.health.producerReady.Store(true)
+	kafkaProducerPoolSize.Set(float64(e.kafkaProducerPool.Load().size))
 }
 
 func (e *Engine) startKafkaConsumer() {
 	e.logger.Info("Starting Kafka consumer")
 
 	var kafkaConsumerLogger *zap.Logger
-	if flags.FlagKafkaLogging {
+	if flags.FlagKafkaLogging || e.cfg.App.Features.KafkaLogging {
 		kafkaConsumerLogger = logging.GetLogger("kafka.consumer")
 	} else {
 		kafkaConsumerLogger = zap.NewNop()
 	}
 
-	// Define Kafka consumer config
-	var consumerConfig *config.KafkaConsumerConfig
-	if flags.FlagEnvironment == "development" {
-		consumerConfig = config.NewKafkaConsumerConfig("localhost:9092", "rubicon_kafka_dse_development", "dse-development-consumer-group")
-	} else {
-		consumerConfig = config.NewKafkaConsumerConfig("localhost:9092", "rubicon_kafka_dse", "dse-consumer-group")
-	}
+	// Initialize Kafka Consumer and subscribe, waiting for Kafka while it is unavailable
+	connected := e.superviseKafka(kafkaClientConsumer, func() error {
+		kafkaConsumer, err := newKafkaConsumer(e.ctx, e.cfg.App.Kafka, kafkaConsumerLogger, e.inputCh)
+		if err != nil {
+			return err
+		}
 
-	// Initialize Kafka Consumer Pool
-	kafkaConsumer, err := consumer.NewKafkaConsumer(e.ctx, consumerConfig, kafkaConsumerLogger)
-	if err != nil {
-		log.Fatalf("Failed to create Kafka consumer pool: %v", err)
+		if err := kafkaConsumer.Subscribe(); err != nil {
+			kafkaConsumer.Close()
+			return err
+		}
+
+		kafkaConsumer.onConnectionState = func(connected bool, err error) {
+			e.setKafkaConnected(kafkaClientConsumer, connected, err)
+		}
+
+		e.kafkaConsumer = kafkaConsumer
+		return nil
+	})
+	if !connected {
+		return
 	}
 
-	e.kafkaConsumer = kafkaConsumer
+	kafkaConsumersRunning.Inc()
 
 	// Start Kafka consumer
 	e.wg.Add(1)
 	go func() {
 		defer e.wg.Done()
-		e.kafkaConsumer.Start()
+		e.kafkaConsumer.Run()
 	}()
 }
+
+// setKafkaConnected records a change of the connection of a running Kafka client
+func (e *Engine) setKafkaConnected(client string, connected bool, err error) {
+	if connected {
+		e.setConnectionState(client, connectionStateConnected, nil)
+	} else {
+		e.setConnectionState(client, connectionStateWaiting, err)
+	}
+}
+
+// setKafkaOptions sets the timeouts and client options shared by the Kafka clients, followed by the options of the client itself
+func setKafkaOptions(configMap *kafka.ConfigMap, cfg app.KafkaConfig, clientOptions map[string]string) error {
+	if cfg.SocketTimeoutMs > 0 {
+		configMap.SetKey("socket.timeout.ms", cfg.SocketTimeoutMs)
+	}
+
+	for _, options := range []map[string]string{cfg.ClientOptions, clientOptions} {
+		for key, value := range options {
+			if err := configMap.SetKey(key, value); err != nil {
+				return fmt.Errorf("invalid Kafka option %s: %w", key, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// kafkaConnectTimeout returns the time a Kafka client waits for the brokers when it connects
+func kafkaConnectTimeout(cfg app.KafkaConfig) time.Duration {
+	if cfg.ConnectTimeoutMs > 0 {
+		return time.Duration(cfg.ConnectTimeoutMs) * time.Millisecond
+	}
+
+	return defaultKafkaConnectTimeout
+}
+
+// probeKafka requests the cluster metadata, since Kafka clients are created without connecting to the brokers.
+// It returns an error if no broker answers in time.
+func probeKafka(client interface {
+	GetMetadata(topic *string, allTopics bool, timeoutMs int) (*kafka.Metadata, error)
+}, cfg app.KafkaConfig) error {
+	metadata, err := client.GetMetadata(nil, false, int(kafkaConnectTimeout(cfg).Milliseconds()))
+	if err != nil {
+		return fmt.Errorf("brokers not reachable: %w", err)
+	}
+
+	if len(metadata.Brokers) == 0 {
+		return fmt.Errorf("brokers not reachable: no brokers in cluster metadata")
+	}
+
+	return nil
+}
+
+// brokersUp reports whether the statistics of a Kafka client show a connected broker
+func brokersUp(stats *kafka.Stats) bool {
+	var statistics struct {
+		Brokers map[string]struct {
+			State string `json:"state"`
+		} `json:"brokers"`
+	}
+	if err := json.Unmarshal([]byte(stats.String()), &statistics); err != nil {
+		return false
+	}
+
+	for _, broker := range statistics.Brokers {
+		if broker.State == "UP" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics
+var (
+	workerPoolSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "dse_worker_pool_size",
+		Help: "Number of shards processing messages concurrently",
+	})
+
+	workerQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "dse_worker_queue_depth",
+		Help: "Capacity of the message queue of each shard",
+	})
+
+	shardBacklog = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dse_worker_shard_backlog",
+		Help: "Current number of messages queued on a shard",
+	}, []string{"shard"})
+)
+
+var (
+	messagesConsumed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "dse_worker_messages_consumed_total",
+		Help: "Total number of messages consumed from Kafka",
+	})
+
+	messagesDecoded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dse_worker_messages_decoded_total",
+		Help: "Total number of messages decoded, by decoder",
+	}, []string{"decoder"})
+
+	messagesProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dse_worker_messages_processed_total",
+		Help: "Total number of device records processed, by decoder and customer",
+	}, []string{"decoder", "customer"})
+
+	messagesPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dse_worker_messages_published_total",
+		Help: "Total number of device records published, by decoder and customer",
+	}, []string{"decoder", "customer"})
+
+	processingErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dse_worker_errors_total",
+		Help: "Total number of processing errors, by error category",
+	}, []string{"category"})
+
+	processingLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dse_worker_processing_duration_seconds",
+		Help:    "Time taken to process and publish a message, by decoder",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"decoder"})
+
+	messageLag = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dse_worker_message_lag_seconds",
+		Help:    "Time between the message timestamp and its processing, by decoder",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 16),
+	}, []string{"decoder"})
+
+	kafkaProducerPoolSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "dse_worker_kafka_producer_pool_size",
+		Help: "Number of producers in the Kafka producer pool, 0 while the pool is not available",
+	})
+
+	kafkaConsumersRunning = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "dse_worker_kafka_consumers_running",
+		Help: "Number of Kafka consumers running",
+	})
+
+	sinkDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dse_worker_sink_deliveries_total",
+		Help: "Total number of record publish attempts, by output sink, topic and result",
+	}, []string{"sink", "topic", "result"})
+
+	messagesAcked = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "dse_worker_messages_acked_total",
+		Help: "Total number of input messages fully handled and eligible for offset commit",
+	})
+
+	duplicatesSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dse_worker_duplicates_total",
+		Help: "Total number of duplicates skipped, by kind (payload_id or device_timestamp)",
+	}, []string{"kind"})
+
+	consumptionPaused = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "dse_worker_consumption_paused",
+		Help: "Whether consumption of input messages is paused",
+	})
+
+	kafkaConnected = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dse_worker_kafka_connected",
+		Help: "Whether a Kafka client is connected (1) or waiting for Kafka (0), by client",
+	}, []string{"client"})
+
+	controllerEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dse_worker_controller_events_total",
+		Help: "Controller offline and online events published, by event",
+	}, []string{"event"})
+
+	alarmEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dse_worker_alarm_events_total",
+		Help: "Device alarm events published, by alarm and event",
+	}, []string{"alarm", "event"})
+
+	runSessionEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dse_worker_run_session_events_total",
+		Help: "Genset run sessions started and stopped, by event",
+	}, []string{"event"})
+)
@@ -0,0 +1,49 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+// startMonitoringServer serves the monitoring endpoints until the engine stops
+func (e *Engine) startMonitoringServer() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", e.handleHealthz)
+	mux.HandleFunc("/readyz", e.handleReadyz)
+
+	server := &http.Server{
+		Addr:              e.cfg.App.Monitoring.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	e.logger.Info("Starting monitoring server", zap.String("address", server.Addr))
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, http.ErrServerClosed) {
+			e.logger.Error("Monitoring server failed", zap.Error(err))
+		}
+		return
+	case <-e.ctx.Done():
+	}
+
+	// Gracefully shut down the server with a timeout
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		e.logger.Error("Failed to shut down monitoring server", zap.Error(err))
+	}
+}
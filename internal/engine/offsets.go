@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"sync"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+// partitionOffsets holds the offsets of a partition that are in flight, in consumption order
+type partitionOffsets struct {
+	inFlight []int64
+	acked    map[int64]bool
+}
+
+// offsetTracker tracks in-flight messages per partition, so that an offset is only committed
+// once it and every offset before it have been acknowledged
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[partitionKey]*partitionOffsets
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{
+		partitions: make(map[partitionKey]*partitionOffsets),
+	}
+}
+
+// track registers a consumed message as in flight
+func (t *offsetTracker) track(tp kafka.TopicPartition) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := partitionKey{topic: *tp.Topic, partition: tp.Partition}
+	offsets, ok := t.partitions[key]
+	if !ok {
+		offsets = &partitionOffsets{acked: make(map[int64]bool)}
+		t.partitions[key] = offsets
+	}
+
+	offsets.inFlight = append(offsets.inFlight, int64(tp.Offset))
+}
+
+// ack acknowledges a message. It returns the offset to commit for its partition,
+// or false if earlier messages of the partition are still in flight.
+func (t *offsetTracker) ack(tp kafka.TopicPartition) (kafka.Offset, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	offsets, ok := t.partitions[partitionKey{topic: *tp.Topic, partition: tp.Partition}]
+	if !ok {
+		// The partition was revoked since the message was consumed
+		return 0, false
+	}
+
+	offsets.acked[int64(tp.Offset)] = true
+
+	committable := int64(-1)
+	for len(offsets.inFlight) > 0 && offsets.acked[offsets.inFlight[0]] {
+		committable = offsets.inFlight[0]
+		delete(offsets.acked, committable)
+		offsets.inFlight = offsets.inFlight[1:]
+	}
+
+	if committable < 0 {
+		return 0, false
+	}
+
+	// The committed offset is the next message to consume
+	return kafka.Offset(committable + 1), true
+}
+
+// inFlight returns the number of messages that have not been acknowledged
+func (t *offsetTracker) inFlight() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	count := 0
+	for _, offsets := range t.partitions {
+		count += len(offsets.inFlight)
+	}
+
+	return count
+}
+
+// reset forgets the in-flight messages of the given partitions
+func (t *offsetTracker) reset(partitions []kafka.TopicPartition) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, tp := range partitions {
+		delete(t.partitions, partitionKey{topic: *tp.Topic, partition: tp.Partition})
+	}
+}
@@ -0,0 +1,169 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// offsetStep is an operation on an offset tracker, with the outcome expected of an ack
+type offsetStep struct {
+	op        string // track, ack or reset
+	partition int32
+	offset    int64
+	commit    int64 // Offset expected to be committed by an ack, -1 for none
+}
+
+func topicPartition(partition int32, offset int64) kafka.TopicPartition {
+	topic := "rubicon_kafka_dse"
+	return kafka.TopicPartition{Topic: &topic, Partition: partition, Offset: kafka.Offset(offset)}
+}
+
+func TestOffsetTracker(t *testing.T) {
+	tests := []struct {
+		name     string
+		steps    []offsetStep
+		inFlight int
+	}{
+		{
+			name: "acks in order",
+			steps: []offsetStep{
+				{op: "track", offset: 10},
+				{op: "track", offset: 11},
+				{op: "ack", offset: 10, commit: 11},
+				{op: "ack", offset: 11, commit: 12},
+			},
+			inFlight: 0,
+		},
+		{
+			name: "out of order acks wait for the gap at the head",
+			steps: []offsetStep{
+				{op: "track", offset: 10},
+				{op: "track", offset: 11},
+				{op: "track", offset: 12},
+				{op: "ack", offset: 12, commit: -1},
+				{op: "ack", offset: 11, commit: -1},
+				{op: "ack", offset: 10, commit: 13},
+			},
+			inFlight: 0,
+		},
+		{
+			name: "unacked head holds back the partition",
+			steps: []offsetStep{
+				{op: "track", offset: 10},
+				{op: "track", offset: 11},
+				{op: "track", offset: 12},
+				{op: "ack", offset: 11, commit: -1},
+				{op: "ack", offset: 12, commit: -1},
+			},
+			inFlight: 3,
+		},
+		{
+			name: "gap in the middle commits up to the gap",
+			steps: []offsetStep{
+				{op: "track", offset: 10},
+				{op: "track", offset: 11},
+				{op: "track", offset: 12},
+				{op: "ack", offset: 12, commit: -1},
+				{op: "ack", offset: 10, commit: 11},
+				{op: "ack", offset: 11, commit: 13},
+			},
+			inFlight: 0,
+		},
+		{
+			name: "non-consecutive offsets",
+			steps: []offsetStep{
+				{op: "track", offset: 10},
+				{op: "track", offset: 15},
+				{op: "ack", offset: 15, commit: -1},
+				{op: "ack", offset: 10, commit: 16},
+			},
+			inFlight: 0,
+		},
+		{
+			name: "partitions are independent",
+			steps: []offsetStep{
+				{op: "track", partition: 0, offset: 10},
+				{op: "track", partition: 1, offset: 20},
+				{op: "ack", partition: 1, offset: 20, commit: 21},
+				{op: "ack", partition: 0, offset: 10, commit: 11},
+			},
+			inFlight: 0,
+		},
+		{
+			name: "reset on revocation forgets in-flight offsets",
+			steps: []offsetStep{
+				{op: "track", offset: 10},
+				{op: "track", offset: 11},
+				{op: "reset"},
+			},
+			inFlight: 0,
+		},
+		{
+			name: "late ack after revocation commits nothing",
+			steps: []offsetStep{
+				{op: "track", offset: 10},
+				{op: "track", offset: 11},
+				{op: "reset"},
+				{op: "ack", offset: 10, commit: -1},
+				{op: "ack", offset: 11, commit: -1},
+			},
+			inFlight: 0,
+		},
+		{
+			name: "redelivered offsets are tracked anew after reassignment",
+			steps: []offsetStep{
+				{op: "track", offset: 10},
+				{op: "reset"},
+				{op: "track", offset: 10},
+				{op: "track", offset: 11},
+				{op: "ack", offset: 11, commit: -1},
+				{op: "ack", offset: 10, commit: 12},
+			},
+			inFlight: 0,
+		},
+		{
+			name: "reset only forgets its partitions",
+			steps: []offsetStep{
+				{op: "track", partition: 0, offset: 10},
+				{op: "track", partition: 1, offset: 20},
+				{op: "reset", partition: 0},
+				{op: "ack", partition: 0, offset: 10, commit: -1},
+			},
+			inFlight: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newOffsetTracker()
+
+			for i, step := range tt.steps {
+				tp := topicPartition(step.partition, step.offset)
+
+				switch step.op {
+				case "track":
+					tracker.track(tp)
+				case "reset":
+					tracker.reset([]kafka.TopicPartition{tp})
+				case "ack":
+					offset, ok := tracker.ack(tp)
+					switch {
+					case step.commit < 0 && ok:
+						t.Errorf("step %d: ack of %d committed %d, want no commit", i, step.offset, offset)
+					case step.commit >= 0 && !ok:
+						t.Errorf("step %d: ack of %d committed nothing, want %d", i, step.offset, step.commit)
+					case step.commit >= 0 && int64(offset) != step.commit:
+						t.Errorf("step %d: ack of %d committed %d, want %d", i, step.offset, offset, step.commit)
+					}
+				default:
+					t.Fatalf("step %d: unknown operation %s", i, step.op)
+				}
+			}
+
+			if got := tracker.inFlight(); got != tt.inFlight {
+				t.Errorf("in flight = %d, want %d", got, tt.inFlight)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"errors"
+	"time"
+
+	"github.com/johandrevandeventer/dse-worker/internal/config/app"
+	"github.com/johandrevandeventer/dse-worker/internal/workers"
+	"github.com/johandrevandeventer/kafkaclient/payload"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// errorMessages maps error categories to their log messages
+var errorMessages = map[string]string{
+	workers.CategoryControllerIgnored: "Controller is ignored",
+	workers.CategoryDeviceIgnored:     "Device is ignored",
+	workers.CategoryDeviceNotFound:    "Device not found",
+	workers.CategoryCustomerUnknown:   "Customer not found",
+	workers.CategoryCustomer:          "Customer validation failed",
+	workers.CategoryDeserialize:       "Failed to deserialize data",
+	workers.CategoryDecode:            "Decoding failed",
+	workers.CategoryPublish:           "Failed to publish data",
+}
+
+// errorPolicy returns the handling policy of an error category
+func (e *Engine) errorPolicy(category string) app.ErrorPolicyConfig {
+	if policy, ok := e.appConfig().ErrorPolicies[category]; ok {
+		return policy
+	}
+
+	return app.ErrorPolicyConfig{LogLevel: "error", Action: app.ErrorActionDeadLetter}
+}
+
+// handleWorkerError logs a worker error and applies the policy of its category.
+// It returns true for retry when the message should be retried, and true for handled
+// when the message is done with and its offset may be committed.
+func (e *Engine) handleWorkerError(p payload.Payload, err error, attempt int) (retry bool, handled bool) {
+	category := workers.ErrorCategory(err)
+	policy := e.errorPolicy(category)
+
+	level, parseErr := zapcore.ParseLevel(policy.LogLevel)
+	if parseErr != nil {
+		level = zapcore.ErrorLevel
+	}
+
+	message, ok := errorMessages[category]
+	if !ok {
+		message = "Processing failed"
+	}
+
+	processingErrors.WithLabelValues(category).Inc()
+	e.stats.failed(category)
+
+	fields := append(errorFields(err), zap.String("category", category), zap.Int("attempt", attempt))
+	e.logger.Log(level, message, fields...)
+
+	switch policy.Action {
+	case app.ErrorActionDrop:
+		return false, true
+	case app.ErrorActionRetry:
+		if attempt <= policy.MaxRetries {
+			// A message interrupted by a stop is left uncommitted, to be redelivered
+			return e.waitRetry(policy, attempt), false
+		}
+	}
+
+	// The offset of a message that is not dead-lettered holds back the commits of its partition, so the dead letter is
+	// retried until it is published. Only a stop leaves the message uncommitted, to be redelivered after the restart.
+	if err := e.publishDeadLetterWithRetry(p, err, attempt); err != nil {
+		e.logger.Error("Message left uncommitted, it will be redelivered", zap.String("id", p.ID.String()), zap.Error(err))
+		return false, false
+	}
+
+	return false, true
+}
+
+// waitRetry waits for the backoff of a retry attempt. It returns false if the engine is stopped meanwhile.
+func (e *Engine) waitRetry(policy app.ErrorPolicyConfig, attempt int) bool {
+	backoff := time.Duration(policy.RetryBackoffMs) * time.Millisecond << (attempt - 1)
+
+	select {
+	case <-e.workCtx.Done():
+		return false
+	case <-time.After(backoff):
+		return true
+	}
+}
+
+// errorFields returns the identifiers carried by a worker error as log fields
+func errorFields(err error) []zap.Field {
+	var controllerIgnored workers.ErrControllerIgnored
+	var deviceIgnored workers.ErrDeviceIgnored
+	var deviceNotFound workers.ErrDeviceNotFound
+	var customerUnknown workers.ErrCustomerUnknown
+
+	switch {
+	case errors.As(err, &controllerIgnored):
+		return []zap.Field{zap.String("controllerID", controllerIgnored.ID), zap.Any("rule", controllerIgnored.Rule)}
+	case errors.As(err, &deviceIgnored):
+		return []zap.Field{zap.String("deviceID", deviceIgnored.ID), zap.Any("rule", deviceIgnored.Rule)}
+	case errors.As(err, &deviceNotFound):
+		return []zap.Field{zap.String("deviceID", deviceNotFound.ID)}
+	case errors.As(err, &customerUnknown):
+		return []zap.Field{zap.String("customer", customerUnknown.Name)}
+	}
+
+	return []zap.Field{zap.Error(err)}
+}
@@ -0,0 +1,319 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/johandrevandeventer/dse-worker/internal/config/app"
+	"go.uber.org/zap"
+)
+
+// transactionAbortTimeout bounds the abort of a failed transaction
+const transactionAbortTimeout = 10 * time.Second
+
+// pooledProducer is a producer of the pool, with the transactional ID it was created with
+type pooledProducer struct {
+	producer        *kafka.Producer
+	transactionalID string
+}
+
+// kafkaProducerPool is a pool of Kafka producers whose sends wait for the broker to confirm delivery.
+// In transactional mode, the records of a send are published in a single transaction.
+type kafkaProducerPool struct {
+	ctx       context.Context
+	cfg       app.KafkaConfig
+	producers chan *pooledProducer
+	size      int
+	logger    *zap.Logger
+
+	// Called when the brokers become unavailable or available again
+	onConnectionState func(connected bool, err error)
+	brokersDown       atomic.Bool
+
+	// Called before a transaction is committed, an error aborts the transaction. Only set by tests.
+	beforeCommit func() error
+}
+
+func newKafkaProducerPool(ctx context.Context, cfg app.KafkaConfig, logger *zap.Logger) (*kafkaProducerPool, error) {
+	poolSize := max(cfg.ProducerPoolSize, 1)
+
+	pool := &kafkaProducerPool{
+		ctx:       ctx,
+		cfg:       cfg,
+		producers: make(chan *pooledProducer, poolSize),
+		size:      poolSize,
+		logger:    logger,
+	}
+
+	for i := 0; i < poolSize; i++ {
+		var transactionalID string
+		if pool.transactional() {
+			transactionalID = fmt.Sprintf("%s-%d", cfg.TransactionalID, i)
+		}
+
+		producer, err := pool.newProducer(transactionalID)
+		if err != nil {
+			pool.closeProducers()
+			return nil, err
+		}
+
+		pool.producers <- producer
+	}
+
+	logger.Info("Kafka producer pool created successfully", zap.Int("pool_size", poolSize), zap.String("publish_mode", cfg.PublishMode))
+
+	return pool, nil
+}
+
+// transactional reports whether the records of a send are published in a transaction
+func (kpp *kafkaProducerPool) transactional() bool {
+	return kpp.cfg.PublishMode == app.PublishModeTransactional
+}
+
+// newProducer creates a producer, and initializes its transactions when a transactional ID is given
+func (kpp *kafkaProducerPool) newProducer(transactionalID string) (*pooledProducer, error) {
+	configMap := &kafka.ConfigMap{
+		"bootstrap.servers":      strings.Join(kpp.cfg.Brokers, ","),
+		"acks":                   "all",
+		"statistics.interval.ms": int(kafkaStatisticsInterval.Milliseconds()),
+		"log_level":              0,
+	}
+
+	switch {
+	case transactionalID != "":
+		configMap.SetKey("transactional.id", transactionalID)
+	case kpp.cfg.PublishMode == app.PublishModeIdempotent:
+		configMap.SetKey("enable.idempotence", true)
+	}
+
+	if kpp.cfg.MessageTimeoutMs > 0 {
+		configMap.SetKey("message.timeout.ms", kpp.cfg.MessageTimeoutMs)
+	}
+
+	if err := setKafkaOptions(configMap, kpp.cfg, kpp.cfg.ProducerOptions); err != nil {
+		return nil, err
+	}
+
+	producer, err := kafka.NewProducer(configMap)
+	if err != nil {
+		return nil, err
+	}
+
+	// Log errors reported outside of delivery reports
+	go kpp.handleEvents(producer)
+
+	if err := probeKafka(producer, kpp.cfg); err != nil {
+		producer.Close()
+		return nil, err
+	}
+
+	if transactionalID != "" {
+		ctx, cancel := context.WithTimeout(kpp.ctx, kafkaConnectTimeout(kpp.cfg))
+		defer cancel()
+
+		if err := producer.InitTransactions(ctx); err != nil {
+			producer.Close()
+			return nil, fmt.Errorf("failed to initialize transactions for %s: %w", transactionalID, err)
+		}
+	}
+
+	return &pooledProducer{producer: producer, transactionalID: transactionalID}, nil
+}
+
+// handleEvents logs the producer events that are not delivery reports, until the producer is closed
+func (kpp *kafkaProducerPool) handleEvents(producer *kafka.Producer) {
+	for ev := range producer.Events() {
+		switch e := ev.(type) {
+		case kafka.Error:
+			kpp.logger.Error("Kafka producer error", zap.Error(e))
+			if e.Code() == kafka.ErrAllBrokersDown {
+				kpp.setBrokersDown(true, e)
+			}
+		case *kafka.Message:
+			// Delivery reports of transactional records, their outcome is that of the transaction
+			if e.TopicPartition.Error != nil {
+				kpp.logger.Warn("Failed to deliver transactional record", zap.String("kafka_topic", *e.TopicPartition.Topic), zap.Error(e.TopicPartition.Error))
+			}
+		case *kafka.Stats:
+			// Without records to send, the statistics tell when the brokers are back
+			if brokersUp(e) {
+				kpp.setBrokersDown(false, nil)
+			}
+		}
+	}
+}
+
+// setBrokersDown reports a change of the availability of the brokers
+func (kpp *kafkaProducerPool) setBrokersDown(down bool, err error) {
+	if kpp.brokersDown.Swap(down) == down {
+		return
+	}
+
+	if kpp.onConnectionState != nil {
+		kpp.onConnectionState(!down, err)
+	}
+}
+
+// Produce sends records and waits until all of them are delivered
+func (kpp *kafkaProducerPool) Produce(ctx context.Context, records []Record) (err error) {
+	var producer *pooledProducer
+	select {
+	case producer = <-kpp.producers:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// A delivered send shows the brokers are available
+	defer func() {
+		if err == nil {
+			kpp.setBrokersDown(false, nil)
+		}
+	}()
+
+	if kpp.transactional() {
+		// The producer is held for the whole transaction
+		defer func() { kpp.producers <- producer }()
+		return kpp.produceTransaction(ctx, producer, records)
+	}
+
+	deliveryChan := make(chan kafka.Event, len(records))
+	for _, r := range records {
+		err := producer.producer.Produce(&kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &r.Topic, Partition: kafka.PartitionAny},
+			Value:          r.Value,
+		}, deliveryChan)
+		if err != nil {
+			kpp.producers <- producer
+			return err
+		}
+	}
+	kpp.producers <- producer
+
+	for range records {
+		select {
+		case ev := <-deliveryChan:
+			m, ok := ev.(*kafka.Message)
+			if !ok {
+				return fmt.Errorf("unexpected delivery event: %v", ev)
+			}
+
+			if m.TopicPartition.Error != nil {
+				return m.TopicPartition.Error
+			}
+
+			kpp.logger.Debug("Message delivered", zap.String("kafka_topic", *m.TopicPartition.Topic), zap.Int32("partition", m.TopicPartition.Partition), zap.Int64("offset", int64(m.TopicPartition.Offset)))
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// produceTransaction publishes records in a single transaction, aborting it on failure
+func (kpp *kafkaProducerPool) produceTransaction(ctx context.Context, producer *pooledProducer, records []Record) error {
+	// A producer that could not be replaced after a failed transaction is created again, once the brokers are back
+	if producer.producer == nil {
+		replacement, err := kpp.newProducer(producer.transactionalID)
+		if err != nil {
+			return err
+		}
+		*producer = *replacement
+	}
+
+	err := producer.producer.BeginTransaction()
+	if err != nil {
+		return kpp.recoverTransaction(producer, err)
+	}
+
+	for _, r := range records {
+		err = producer.producer.Produce(&kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &r.Topic, Partition: kafka.PartitionAny},
+			Value:          r.Value,
+		}, nil)
+		if err != nil {
+			return kpp.recoverTransaction(producer, err)
+		}
+	}
+
+	if kpp.beforeCommit != nil {
+		if err := kpp.beforeCommit(); err != nil {
+			return kpp.recoverTransaction(producer, err)
+		}
+	}
+
+	err = producer.producer.CommitTransaction(ctx)
+	if err != nil {
+		return kpp.recoverTransaction(producer, err)
+	}
+
+	kpp.logger.Debug("Transaction committed", zap.String("transactional_id", producer.transactionalID), zap.Int("records", len(records)))
+	return nil
+}
+
+// recoverTransaction aborts a failed transaction so the producer can be reused.
+// A producer that cannot abort is replaced by a new one. It returns the transaction error.
+func (kpp *kafkaProducerPool) recoverTransaction(producer *pooledProducer, txnErr error) error {
+	var kafkaErr kafka.Error
+	fatal := errors.As(txnErr, &kafkaErr) && kafkaErr.IsFatal()
+
+	if !fatal {
+		ctx, cancel := context.WithTimeout(context.Background(), transactionAbortTimeout)
+		defer cancel()
+
+		err := producer.producer.AbortTransaction(ctx)
+		if err == nil {
+			kpp.logger.Warn("Transaction aborted", zap.String("transactional_id", producer.transactionalID), zap.Error(txnErr))
+			return txnErr
+		}
+
+		kpp.logger.Error("Failed to abort transaction", zap.String("transactional_id", producer.transactionalID), zap.Error(err))
+	}
+
+	kpp.logger.Warn("Replacing transactional producer", zap.String("transactional_id", producer.transactionalID), zap.Error(txnErr))
+
+	producer.producer.Close()
+	replacement, err := kpp.newProducer(producer.transactionalID)
+	if err != nil {
+		producer.producer = nil
+		return fmt.Errorf("%w (failed to replace producer: %v)", txnErr, err)
+	}
+
+	*producer = *replacement
+	return txnErr
+}
+
+// Close flushes and closes the producers
+func (kpp *kafkaProducerPool) Close() {
+	kpp.logger.Info("Closing Kafka producer pool...")
+
+	for len(kpp.producers) > 0 {
+		producer := <-kpp.producers
+		if producer.producer == nil {
+			continue
+		}
+
+		remaining := producer.producer.Flush(5000) // 5-second timeout
+		if remaining > 0 {
+			kpp.logger.Warn("Failed to flush all messages", zap.Int("remaining_messages", remaining))
+		}
+
+		producer.producer.Close()
+	}
+
+	kpp.logger.Info("Kafka producer pool closed successfully")
+}
+
+func (kpp *kafkaProducerPool) closeProducers() {
+	for len(kpp.producers) > 0 {
+		producer := <-kpp.producers
+		if producer.producer != nil {
+			producer.producer.Close()
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/google/uuid"
+	"github.com/johandrevandeventer/dse-worker/internal/config/app"
+	"go.uber.org/zap"
+)
+
+// Transactional publishing is tested against a local single-broker cluster, set by these environment variables:
+//
+//	KAFKA_TEST_BROKERS       bootstrap servers, e.g. localhost:9092. The tests are skipped when not set.
+//	KAFKA_TEST_BROKER_STOP   shell command killing the broker, e.g. "docker kill kafka"
+//	KAFKA_TEST_BROKER_START  shell command starting the broker again, e.g. "docker start kafka"
+//
+// The broker needs transaction.state.log.replication.factor=1 and transaction.state.log.min.isr=1.
+// The broker failure test is skipped when the stop and start commands are not set.
+
+// testMessageRecords are the records of an input message: its Pre and Post data and the Post data of a second route
+var testMessageRecords = []string{"influxdb.Pre", "influxdb.Post", "kodelabs.Post"}
+
+// testRecord is the value of a record published by the tests
+type testRecord struct {
+	Message int    `json:"message"`
+	Output  string `json:"output"`
+}
+
+func kafkaTestBrokers(t *testing.T) []string {
+	t.Helper()
+
+	brokers := os.Getenv("KAFKA_TEST_BROKERS")
+	if brokers == "" {
+		t.Skip("KAFKA_TEST_BROKERS not set")
+	}
+
+	return strings.Split(brokers, ",")
+}
+
+// createTestTopic creates a single-partition topic with a unique name
+func createTestTopic(t *testing.T, brokers []string) string {
+	t.Helper()
+
+	adminClient, err := kafka.NewAdminClient(&kafka.ConfigMap{"bootstrap.servers": strings.Join(brokers, ",")})
+	if err != nil {
+		t.Fatalf("failed to create admin client: %v", err)
+	}
+	defer adminClient.Close()
+
+	topic := fmt.Sprintf("dse_worker_transaction_test_%s", uuid.NewString())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	results, err := adminClient.CreateTopics(ctx, []kafka.TopicSpecification{{Topic: topic, NumPartitions: 1, ReplicationFactor: 1}})
+	if err != nil {
+		t.Fatalf("failed to create topic: %v", err)
+	}
+	for _, result := range results {
+		if result.Error.Code() != kafka.ErrNoError {
+			t.Fatalf("failed to create topic %s: %v", result.Topic, result.Error)
+		}
+	}
+
+	return topic
+}
+
+// newTestProducerPool creates a transactional producer pool of a single producer
+func newTestProducerPool(t *testing.T, brokers []string) *kafkaProducerPool {
+	t.Helper()
+
+	cfg := app.KafkaConfig{
+		Brokers:          brokers,
+		ProducerPoolSize: 1,
+		PublishMode:      app.PublishModeTransactional,
+		TransactionalID:  fmt.Sprintf("dse-worker-test-%s", uuid.NewString()),
+	}
+
+	pool, err := newKafkaProducerPool(context.Background(), cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("failed to create producer pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+
+	return pool
+}
+
+// messageRecords returns the records of an input message
+func messageRecords(t *testing.T, topic string, message int) []Record {
+	t.Helper()
+
+	records := make([]Record, 0, len(testMessageRecords))
+	for _, output := range testMessageRecords {
+		value, err := json.Marshal(testRecord{Message: message, Output: output})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		records = append(records, Record{Sink: defaultSinkName, Topic: topic, Value: value})
+	}
+
+	return records
+}
+
+// readCommittedRecords reads the committed records of a topic, by message, until no record arrives for a while
+func readCommittedRecords(t *testing.T, brokers []string, topic string) map[int][]string {
+	t.Helper()
+
+	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers":  strings.Join(brokers, ","),
+		"group.id":           fmt.Sprintf("dse-worker-test-%s", uuid.NewString()),
+		"auto.offset.reset":  "earliest",
+		"isolation.level":    "read_committed",
+		"enable.auto.commit": false,
+	})
+	if err != nil {
+		t.Fatalf("failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+
+	if err := consumer.Subscribe(topic, nil); err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+
+	records := make(map[int][]string)
+	for {
+		m, err := consumer.ReadMessage(10 * time.Second)
+		if err != nil {
+			var kafkaErr kafka.Error
+			if errors.As(err, &kafkaErr) && kafkaErr.IsTimeout() {
+				return records
+			}
+			t.Fatalf("failed to read: %v", err)
+		}
+
+		var record testRecord
+		if err := json.Unmarshal(m.Value, &record); err != nil {
+			t.Fatalf("invalid record %s: %v", m.Value, err)
+		}
+		records[record.Message] = append(records[record.Message], record.Output)
+	}
+}
+
+// checkAllOrNothing checks that every message has all of its records or none, and all of them when it was published.
+// A message that is published again after a commit with an unknown outcome may have every record more than once.
+func checkAllOrNothing(t *testing.T, records map[int][]string, published map[int]bool) {
+	t.Helper()
+
+	for message, outputs := range records {
+		counts := make(map[string]int)
+		for _, output := range outputs {
+			counts[output]++
+		}
+
+		for _, output := range testMessageRecords {
+			if counts[output] != counts[testMessageRecords[0]] || len(outputs) != counts[output]*len(testMessageRecords) {
+				t.Errorf("message %d has records %v, want all of %v or none", message, outputs, testMessageRecords)
+				break
+			}
+		}
+	}
+
+	for message, ok := range published {
+		if ok && len(records[message]) == 0 {
+			t.Errorf("message %d was published but has no committed records", message)
+		}
+		if !ok && len(records[message]) > 0 {
+			t.Errorf("message %d failed to publish but has committed records %v", message, records[message])
+		}
+	}
+}
+
+func TestTransactionalPublishAbort(t *testing.T) {
+	brokers := kafkaTestBrokers(t)
+	topic := createTestTopic(t, brokers)
+	pool := newTestProducerPool(t, brokers)
+
+	// Every second message fails after its records were produced, before the commit
+	published := make(map[int]bool)
+	for message := 1; message <= 10; message++ {
+		if message%2 == 0 {
+			pool.beforeCommit = func() error { return errors.New("failure before commit") }
+		} else {
+			pool.beforeCommit = nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := pool.Produce(ctx, messageRecords(t, topic, message))
+		cancel()
+
+		if message%2 == 0 && err == nil {
+			t.Fatalf("message %d: transaction committed despite the failure before commit", message)
+		}
+		if message%2 != 0 && err != nil {
+			t.Fatalf("message %d: %v", message, err)
+		}
+		published[message] = err == nil
+	}
+
+	checkAllOrNothing(t, readCommittedRecords(t, brokers, topic), published)
+}
+
+func TestTransactionalPublishBrokerFailure(t *testing.T) {
+	brokers := kafkaTestBrokers(t)
+
+	stopCommand := os.Getenv("KAFKA_TEST_BROKER_STOP")
+	startCommand := os.Getenv("KAFKA_TEST_BROKER_START")
+	if stopCommand == "" || startCommand == "" {
+		t.Skip("KAFKA_TEST_BROKER_STOP and KAFKA_TEST_BROKER_START not set")
+	}
+
+	runCommand := func(command string) {
+		if out, err := exec.Command("sh", "-c", command).CombinedOutput(); err != nil {
+			t.Fatalf("%s failed: %v: %s", command, err, out)
+		}
+	}
+
+	topic := createTestTopic(t, brokers)
+	pool := newTestProducerPool(t, brokers)
+
+	// Make sure the broker is started again if the test fails while it is down
+	brokerDown := false
+	t.Cleanup(func() {
+		if brokerDown {
+			exec.Command("sh", "-c", startCommand).Run()
+		}
+	})
+
+	const failingMessage = 5
+	published := make(map[int]bool)
+	for message := 1; message <= 10; message++ {
+		pool.beforeCommit = nil
+		if message == failingMessage {
+			// Kill the broker after the records of the message were produced, before the commit
+			pool.beforeCommit = func() error {
+				runCommand(stopCommand)
+				brokerDown = true
+				return nil
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := pool.Produce(ctx, messageRecords(t, topic, message))
+		cancel()
+		published[message] = err == nil
+
+		if message == failingMessage {
+			t.Logf("message %d published while the broker was killed: %v", message, err)
+
+			runCommand(startCommand)
+			brokerDown = false
+			continue
+		}
+
+		// The producer recovers once the broker is back
+		for deadline := time.Now().Add(2 * time.Minute); err != nil && time.Now().Before(deadline); {
+			time.Sleep(time.Second)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			err = pool.Produce(ctx, messageRecords(t, topic, message))
+			cancel()
+		}
+		if err != nil {
+			t.Fatalf("message %d: %v", message, err)
+		}
+		published[message] = true
+	}
+
+	// The message that was in flight when the broker was killed may have landed or not, but not in part
+	records := readCommittedRecords(t, brokers, topic)
+	if !published[failingMessage] {
+		delete(published, failingMessage)
+	}
+	checkAllOrNothing(t, records, published)
+}
@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/johandrevandeventer/dse-worker/internal/config/app"
+	"github.com/johandrevandeventer/dse-worker/internal/workers/types"
+)
+
+// routeTarget is a topic of an output sink
+type routeTarget struct {
+	sink  string
+	topic string
+}
+
+// routeTargets returns the sink topics a record is published to, in route order and without duplicates
+func (e *Engine) routeTargets(data *types.DataStruct) []routeTarget {
+	var targets []routeTarget
+	for _, route := range e.appConfig().Routes {
+		if !routeMatches(route, data) {
+			continue
+		}
+
+		for _, topic := range route.Topics {
+			target := routeTarget{sink: routeSink(route), topic: topic}
+			if topic != "" && !slices.Contains(targets, target) {
+				targets = append(targets, target)
+			}
+		}
+	}
+
+	return targets
+}
+
+// RouteTopics returns the topics the routing table publishes a record to, whatever their sink
+func RouteTopics(routes []app.RouteConfig, data *types.DataStruct) []string {
+	var topics []string
+	for _, route := range routes {
+		if !routeMatches(route, data) {
+			continue
+		}
+
+		for _, topic := range route.Topics {
+			if topic != "" && !slices.Contains(topics, topic) {
+				topics = append(topics, topic)
+			}
+		}
+	}
+
+	return topics
+}
+
+// routeSink returns the name of the output sink of a route
+func routeSink(route app.RouteConfig) string {
+	if route.Sink == "" {
+		return defaultSinkName
+	}
+
+	return route.Sink
+}
+
+// routeMatches reports whether an enabled route accepts a record
+func routeMatches(route app.RouteConfig, data *types.DataStruct) bool {
+	return route.Enabled &&
+		filterMatches(route.Customers, data.CustomerName) &&
+		filterMatches(route.Sites, data.SiteName) &&
+		filterMatches(route.DeviceTypes, data.DeviceType) &&
+		filterMatches(route.States, data.State)
+}
+
+// filterMatches reports whether a value is accepted by a route filter. An empty filter accepts any value.
+func filterMatches(filter []string, value string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+
+	return slices.ContainsFunc(filter, func(accepted string) bool {
+		return strings.EqualFold(accepted, value)
+	})
+}
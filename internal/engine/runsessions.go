@@ -0,0 +1,420 @@
+package engine
+
+import (
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/johandrevandeventer/dse-worker/internal/config/app"
+	"github.com/johandrevandeventer/dse-worker/internal/workers/types"
+	"go.uber.org/zap"
+)
+
+// runSessionDevice is the genset of a run session, so that a session can end without a message of the genset
+type runSessionDevice struct {
+	CustomerID           uuid.UUID `json:"customer_id"`
+	CustomerName         string    `json:"customer_name"`
+	SiteID               uuid.UUID `json:"site_id"`
+	SiteName             string    `json:"site_name"`
+	Controller           string    `json:"controller"`
+	DeviceType           string    `json:"device_type"`
+	ControllerIdentifier string    `json:"controller_identifier"`
+	DeviceName           string    `json:"device_name"`
+}
+
+// openRunSession is a run session of a genset that has not stopped yet, with the counters at its start
+type openRunSession struct {
+	Device             runSessionDevice `json:"device"`
+	Start              time.Time        `json:"start"`
+	LastRunning        time.Time        `json:"last_running"`  // Last message in which the genset was running
+	LastSeen           time.Time        `json:"last_seen"`     // When the last running message was processed
+	LastRunTime        float64          `json:"last_run_time"` // Counters of the last running message
+	LastGenkWh         float64          `json:"last_genkwh"`
+	LastFuelUsed       float64          `json:"last_fuel_used"`
+	RunTime            float64          `json:"run_time"`
+	GenkWh             float64          `json:"genkwh"`
+	FuelUsed           float64          `json:"fuel_used"`
+	PeakLoadPercentage float64          `json:"peak_load_percentage"`
+	Trigger            string           `json:"trigger,omitempty"`
+}
+
+// newRunSessionDevice returns the genset of a device's run session
+func newRunSessionDevice(device types.Device) runSessionDevice {
+	return runSessionDevice{
+		CustomerID:           device.CustomerID,
+		CustomerName:         device.CustomerName,
+		SiteID:               device.SiteID,
+		SiteName:             device.SiteName,
+		Controller:           device.Controller,
+		DeviceType:           device.DeviceType,
+		ControllerIdentifier: device.ControllerIdentifier,
+		DeviceName:           device.DeviceName,
+	}
+}
+
+// ended returns the run session of a device ending at a time, with the counters read at its end
+func (s openRunSession) ended(device string, end time.Time, runTime float64, genkWh float64, fuelUsed float64) types.RunSession {
+	return types.RunSession{
+		CustomerID:           s.Device.CustomerID,
+		CustomerName:         s.Device.CustomerName,
+		SiteID:               s.Device.SiteID,
+		SiteName:             s.Device.SiteName,
+		Controller:           s.Device.Controller,
+		DeviceType:           s.Device.DeviceType,
+		ControllerIdentifier: s.Device.ControllerIdentifier,
+		DeviceName:           s.Device.DeviceName,
+		DeviceIdentifier:     device,
+		Start:                s.Start,
+		End:                  end,
+		DurationSeconds:      end.Sub(s.Start).Seconds(),
+		RunHours:             counterDelta(s.RunTime, runTime),
+		EnergyKWh:            counterDelta(s.GenkWh, genkWh),
+		FuelUsed:             counterDelta(s.FuelUsed, fuelUsed),
+		PeakLoadPercentage:   s.PeakLoadPercentage,
+		Trigger:              s.Trigger,
+	}
+}
+
+// runSessionUpdate is the new state of the run session of a device, nil once the session ended
+type runSessionUpdate struct {
+	device  string
+	session *openRunSession
+	started bool
+	changed bool // Whether the session changed more than its last running time, so that it is persisted right away
+}
+
+// runSessionTracker tracks the open run session of every genset
+type runSessionTracker struct {
+	mu       sync.Mutex
+	sessions map[string]openRunSession
+}
+
+func newRunSessionTracker() *runSessionTracker {
+	return &runSessionTracker{
+		sessions: make(map[string]openRunSession),
+	}
+}
+
+// get returns the open run session of a device, and false if the device is not running
+func (t *runSessionTracker) get(device string) (openRunSession, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	session, ok := t.sessions[device]
+	return session, ok
+}
+
+// apply records run session updates once their records are published
+func (t *runSessionTracker) apply(updates []runSessionUpdate) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, update := range updates {
+		if update.session == nil {
+			delete(t.sessions, update.device)
+			continue
+		}
+
+		// A session that went quiet may have ended while the message was published
+		if _, ok := t.sessions[update.device]; !ok && !update.started {
+			continue
+		}
+
+		t.sessions[update.device] = *update.session
+	}
+}
+
+// end removes the run session of a device, unless a running message updated it meanwhile.
+// It returns false if the session was updated.
+func (t *runSessionTracker) end(device string, session openRunSession) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current, ok := t.sessions[device]
+	if !ok || !current.LastSeen.Equal(session.LastSeen) {
+		return false
+	}
+
+	delete(t.sessions, device)
+	return true
+}
+
+// snapshot returns the open run session of every device
+func (t *runSessionTracker) snapshot() map[string]openRunSession {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return maps.Clone(t.sessions)
+}
+
+// restore adds the open run sessions from before a restart
+func (t *runSessionTracker) restore(sessions map[string]openRunSession) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for device, session := range sessions {
+		if _, ok := t.sessions[device]; !ok {
+			t.sessions[device] = session
+		}
+	}
+}
+
+// numberField returns a numeric field of processed data, and false if the field is missing
+func numberField(data map[string]any, name string) (float64, bool) {
+	value, ok := data[name].(float64)
+	return value, ok
+}
+
+// gensetRunning reports whether a genset is running, from its engine speed and output power. The run time counter
+// increasing since the last running message keeps a session open through missing speed and power readings, unless
+// the genset went quiet for longer than the maximum gap.
+func gensetRunning(cfg app.RunSessionsConfig, data map[string]any, at time.Time, session openRunSession, open bool) bool {
+	if rpm, ok := numberField(data, "Rpm"); ok && rpm >= cfg.MinRpm && rpm > 0 {
+		return true
+	}
+
+	if power, ok := numberField(data, "GenTotalP"); ok && power >= cfg.MinPowerKw && power > 0 {
+		return true
+	}
+
+	if !open || (cfg.MaxGapSeconds > 0 && at.Sub(session.LastRunning) > time.Duration(cfg.MaxGapSeconds)*time.Second) {
+		return false
+	}
+
+	runTime, ok := numberField(data, "RunTime")
+	return ok && session.LastRunTime > 0 && runTime > session.LastRunTime
+}
+
+// runTrigger returns what started a genset, if known. A mains failure starts a genset in auto mode, a genset not in
+// auto mode was started by hand.
+func runTrigger(statuses map[string]bool) string {
+	if statuses["MainFail"] {
+		return types.RunTriggerMainsFail
+	}
+
+	if auto, ok := statuses["AutoMode"]; ok && !auto {
+		return types.RunTriggerManual
+	}
+
+	return ""
+}
+
+// counterDelta returns the increase of a counter over a run session. Counters read as zero when missing, so a zero
+// reading or a counter that went back, e.g. after a controller reset, gives no delta.
+func counterDelta(start float64, end float64) *float64 {
+	if start <= 0 || end <= 0 || end < start {
+		return nil
+	}
+
+	delta := end - start
+	return &delta
+}
+
+// runSessionRecords returns the records of the run sessions of gensets that stopped, with the updates to apply once the
+// records are published
+func (e *Engine) runSessionRecords(devices []types.Device) ([]Record, []runSessionUpdate, error) {
+	runSessionsCfg := e.cfg.App.RunSessions
+	if !runSessionsCfg.Enabled {
+		return nil, nil, nil
+	}
+
+	maxGap := time.Duration(runSessionsCfg.MaxGapSeconds) * time.Second
+	now := time.Now()
+
+	var records []Record
+	var updates []runSessionUpdate
+	for _, device := range devices {
+		// Only gensets report an engine speed
+		if _, ok := numberField(device.ProcessedData, "Rpm"); !ok {
+			continue
+		}
+
+		session, open := e.runSessions.get(device.DeviceIdentifier)
+		runTime, _ := numberField(device.ProcessedData, "RunTime")
+		genkWh, _ := numberField(device.ProcessedData, "GenkWh")
+		fuelUsed, _ := numberField(device.ProcessedData, "Fuel_Used")
+		load, _ := numberField(device.ProcessedData, "Loadpercentage")
+		running := gensetRunning(runSessionsCfg, device.ProcessedData, device.Timestamp, session, open)
+
+		switch {
+		case running && !open:
+			updates = append(updates, runSessionUpdate{
+				device: device.DeviceIdentifier,
+				session: &openRunSession{
+					Device:             newRunSessionDevice(device),
+					Start:              device.Timestamp,
+					LastRunning:        device.Timestamp,
+					LastSeen:           now,
+					LastRunTime:        runTime,
+					LastGenkWh:         genkWh,
+					LastFuelUsed:       fuelUsed,
+					RunTime:            runTime,
+					GenkWh:             genkWh,
+					FuelUsed:           fuelUsed,
+					PeakLoadPercentage: load,
+					Trigger:            runTrigger(device.Statuses),
+				},
+				started: true,
+			})
+		case running && open:
+			updated := session
+			updated.Device = newRunSessionDevice(device)
+			updated.LastRunning = device.Timestamp
+			updated.LastSeen = now
+			updated.LastRunTime = runTime
+			updated.LastGenkWh = genkWh
+			updated.LastFuelUsed = fuelUsed
+			updated.PeakLoadPercentage = max(session.PeakLoadPercentage, load)
+			if updated.Trigger == "" {
+				updated.Trigger = runTrigger(device.Statuses)
+			}
+
+			// Counters that were missing at the start count from their first reading
+			if updated.RunTime <= 0 {
+				updated.RunTime = runTime
+			}
+			if updated.GenkWh <= 0 {
+				updated.GenkWh = genkWh
+			}
+			if updated.FuelUsed <= 0 {
+				updated.FuelUsed = fuelUsed
+			}
+
+			compared := updated
+			compared.LastRunning = session.LastRunning
+			compared.LastSeen = session.LastSeen
+			compared.LastRunTime = session.LastRunTime
+			compared.LastGenkWh = session.LastGenkWh
+			compared.LastFuelUsed = session.LastFuelUsed
+			updates = append(updates, runSessionUpdate{device: device.DeviceIdentifier, session: &updated, changed: compared != session})
+		case !running && open:
+			// A genset that went quiet while running stopped some time after its last running message
+			end := device.Timestamp
+			if maxGap > 0 && end.Sub(session.LastRunning) > maxGap {
+				end = session.LastRunning
+			}
+
+			session.Device = newRunSessionDevice(device)
+			runSession := session.ended(device.DeviceIdentifier, end, runTime, genkWh, fuelUsed)
+
+			record, err := eventRecord(runSessionsCfg.Sink, runSessionsCfg.Topic, runSession, device.Timestamp)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			records = append(records, record)
+			updates = append(updates, runSessionUpdate{device: device.DeviceIdentifier})
+		}
+	}
+
+	return records, updates, nil
+}
+
+// applyRunSessionUpdates records published run session updates, and persists the open run sessions when a session
+// started, ended or changed more than its last running time
+func (e *Engine) applyRunSessionUpdates(updates []runSessionUpdate) {
+	if len(updates) == 0 {
+		return
+	}
+
+	e.runSessions.apply(updates)
+
+	save := false
+	for _, update := range updates {
+		switch {
+		case update.session == nil:
+			runSessionEvents.WithLabelValues("stopped").Inc()
+			e.logger.Info("Genset stopped", zap.String("device", update.device))
+		case update.started:
+			runSessionEvents.WithLabelValues("started").Inc()
+			e.logger.Info("Genset started", zap.String("device", update.device), zap.String("trigger", update.session.Trigger))
+		case !update.changed:
+			continue
+		}
+
+		save = true
+	}
+
+	if save {
+		e.saveRunSessions()
+	}
+}
+
+// WatchRunSessions ends the run sessions of gensets that went quiet for longer than the maximum gap, at their last
+// running message, and persists the open run sessions. Time during which the worker did not run or consume counts as
+// quiet for no genset.
+func (e *Engine) WatchRunSessions() {
+	interval := time.Duration(max(e.cfg.App.RunSessions.CheckIntervalSeconds, 1)) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Gensets are only quiet since the baseline, which moves along while consumption is suspended
+	baseline := time.Now()
+
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+			if e.consumptionSuspended() {
+				baseline = time.Now()
+				continue
+			}
+
+			e.endQuietRunSessions(baseline)
+			e.saveRunSessions()
+		}
+	}
+}
+
+// endQuietRunSessions publishes the run sessions of gensets that went quiet, and ends them.
+// A session is only ended once its record is published, so that a failed record is retried on the next check.
+func (e *Engine) endQuietRunSessions(baseline time.Time) {
+	cfg := e.cfg.App.RunSessions
+	if cfg.MaxGapSeconds <= 0 {
+		return
+	}
+
+	maxGap := time.Duration(cfg.MaxGapSeconds) * time.Second
+	now := time.Now()
+
+	for device, session := range e.runSessions.snapshot() {
+		if now.Sub(latest(session.LastSeen, baseline)) <= maxGap {
+			continue
+		}
+
+		runSession := session.ended(device, session.LastRunning, session.LastRunTime, session.LastGenkWh, session.LastFuelUsed)
+		err := e.publishEvent(cfg.Sink, cfg.Topic, runSession, now)
+		if err != nil {
+			e.logger.Error("Failed to publish run session, retrying on the next check", zap.String("device", device), zap.Error(err))
+			continue
+		}
+
+		if e.runSessions.end(device, session) {
+			runSessionEvents.WithLabelValues("stopped").Inc()
+			e.logger.Info("Genset went quiet, run session ended at its last running message", zap.String("device", device), zap.Time("last_running", session.LastRunning))
+		}
+	}
+}
+
+// restoreRunSessions restores the open run sessions of the previous run from the persist file, so that a session spanning
+// a restart is reported once, from its real start. It must run before the app state is reset.
+func (e *Engine) restoreRunSessions() {
+	var sessions map[string]openRunSession
+	found, err := readPersistedValue(e.cfg.App.Runtime.PersistFilePath, "app.run_sessions", &sessions)
+	if err != nil {
+		e.logger.Error("Failed to restore open run sessions, starting without them", zap.Error(err))
+		return
+	}
+
+	if found {
+		e.runSessions.restore(sessions)
+		e.verboseDebug("Open run sessions restored", zap.Int("devices", len(sessions)))
+	}
+}
+
+// saveRunSessions writes the open run sessions to the persisted app state
+func (e *Engine) saveRunSessions() {
+	e.statePersister.Set("app.run_sessions", e.runSessions.snapshot())
+}
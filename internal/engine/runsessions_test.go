@@ -0,0 +1,472 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/johandrevandeventer/dse-worker/internal/config/app"
+	"github.com/johandrevandeventer/dse-worker/internal/workers/types"
+)
+
+// runStep is a message of a genset, at a number of minutes from the start of a test
+type runStep struct {
+	minutes  int
+	data     map[string]any
+	statuses map[string]bool
+}
+
+// wantRunSession is a run session expected to be reported, with its start and end in minutes from the start of a test
+type wantRunSession struct {
+	start    int
+	end      int
+	runHours *float64
+	energy   *float64
+	fuel     *float64
+	peak     float64
+	trigger  string
+}
+
+// gensetData returns the processed data of a genset message
+func gensetData(rpm, power, runTime, genkWh, fuelUsed, load float64) map[string]any {
+	return map[string]any{
+		"Rpm":            rpm,
+		"GenTotalP":      power,
+		"RunTime":        runTime,
+		"GenkWh":         genkWh,
+		"Fuel_Used":      fuelUsed,
+		"Loadpercentage": load,
+	}
+}
+
+func float(value float64) *float64 {
+	return &value
+}
+
+func formatFloat(value *float64) string {
+	if value == nil {
+		return "nil"
+	}
+	return fmt.Sprint(*value)
+}
+
+// testRunSessionsConfig ends a session after 10 minutes without messages
+var testRunSessionsConfig = app.RunSessionsConfig{
+	Enabled:       true,
+	Sink:          defaultSinkName,
+	Topic:         "run_sessions",
+	MinRpm:        300,
+	MinPowerKw:    1,
+	MaxGapSeconds: 600,
+}
+
+func runSession(t *testing.T, record Record) types.RunSession {
+	t.Helper()
+
+	var session types.RunSession
+	decodeRecord(t, record, &session)
+	return session
+}
+
+func TestRunSessionRecords(t *testing.T) {
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		steps []runStep
+		want  []wantRunSession
+	}{
+		{
+			name: "started and stopped by engine speed",
+			steps: []runStep{
+				{minutes: 0, data: gensetData(0, 0, 100, 1000, 50, 0)},
+				{minutes: 1, data: gensetData(1500, 20, 100, 1000, 50, 40)},
+				{minutes: 2, data: gensetData(1500, 35, 101, 1100, 60, 70)},
+				{minutes: 3, data: gensetData(1500, 15, 101.5, 1150, 62, 30)},
+				{minutes: 4, data: gensetData(0, 0, 101.5, 1150, 62, 0)},
+				{minutes: 5, data: gensetData(0, 0, 101.5, 1150, 62, 0)},
+			},
+			want: []wantRunSession{
+				{start: 1, end: 4, runHours: float(1.5), energy: float(150), fuel: float(12), peak: 70},
+			},
+		},
+		{
+			name: "started by output power",
+			steps: []runStep{
+				{minutes: 0, data: gensetData(0, 20, 100, 1000, 50, 40)},
+				{minutes: 1, data: gensetData(0, 0.5, 100, 1010, 51, 1)},
+			},
+			want: []wantRunSession{
+				{start: 0, end: 1, runHours: float(0), energy: float(10), fuel: float(1), peak: 40},
+			},
+		},
+		{
+			name: "below the thresholds is not running",
+			steps: []runStep{
+				{minutes: 0, data: gensetData(200, 0.5, 100, 1000, 50, 1)},
+				{minutes: 1, data: gensetData(0, 0, 100, 1000, 50, 0)},
+			},
+		},
+		{
+			name: "run time counter keeps the session open through missing readings",
+			steps: []runStep{
+				{minutes: 0, data: gensetData(1500, 20, 100, 1000, 50, 40)},
+				{minutes: 1, data: gensetData(0, 0, 100.5, 0, 0, 0)},
+				{minutes: 2, data: gensetData(0, 0, 101, 0, 0, 0)},
+				{minutes: 3, data: gensetData(1500, 20, 101.5, 1100, 60, 50)},
+				{minutes: 4, data: gensetData(0, 0, 101.5, 1100, 60, 0)},
+			},
+			want: []wantRunSession{
+				{start: 0, end: 4, runHours: float(1.5), energy: float(100), fuel: float(10), peak: 50},
+			},
+		},
+		{
+			name: "run time counter keeps no session open after the maximum gap",
+			steps: []runStep{
+				{minutes: 0, data: gensetData(1500, 20, 100, 1000, 50, 40)},
+				{minutes: 5, data: gensetData(1500, 20, 100.5, 1050, 55, 40)},
+				{minutes: 30, data: gensetData(0, 0, 101, 1100, 60, 0)},
+			},
+			want: []wantRunSession{
+				{start: 0, end: 5, runHours: float(1), energy: float(100), fuel: float(10), peak: 40},
+			},
+		},
+		{
+			name: "stop within the maximum gap ends at the stopping message",
+			steps: []runStep{
+				{minutes: 0, data: gensetData(1500, 20, 100, 1000, 50, 40)},
+				{minutes: 10, data: gensetData(0, 0, 100, 1000, 50, 0)},
+			},
+			want: []wantRunSession{
+				{start: 0, end: 10, runHours: float(0), energy: float(0), fuel: float(0), peak: 40},
+			},
+		},
+		{
+			name: "stop after the maximum gap ends at the last running message",
+			steps: []runStep{
+				{minutes: 0, data: gensetData(1500, 20, 100, 1000, 50, 40)},
+				{minutes: 2, data: gensetData(1500, 20, 100.5, 1050, 55, 60)},
+				{minutes: 60, data: gensetData(0, 0, 100.5, 1050, 55, 0)},
+			},
+			want: []wantRunSession{
+				{start: 0, end: 2, runHours: float(0.5), energy: float(50), fuel: float(5), peak: 60},
+			},
+		},
+		{
+			name: "counters going back after a controller reset give no delta",
+			steps: []runStep{
+				{minutes: 0, data: gensetData(1500, 20, 100, 1000, 50, 40)},
+				{minutes: 1, data: gensetData(0, 0, 1, 1100, 10, 0)},
+			},
+			want: []wantRunSession{
+				{start: 0, end: 1, energy: float(100), peak: 40},
+			},
+		},
+		{
+			name: "zero counters give no delta",
+			steps: []runStep{
+				{minutes: 0, data: gensetData(1500, 20, 100, 1000, 50, 40)},
+				{minutes: 1, data: gensetData(0, 0, 0, 0, 60, 0)},
+			},
+			want: []wantRunSession{
+				{start: 0, end: 1, fuel: float(10), peak: 40},
+			},
+		},
+		{
+			name: "counters missing at the start count from their first reading",
+			steps: []runStep{
+				{minutes: 0, data: gensetData(1500, 20, 0, 0, 0, 40)},
+				{minutes: 1, data: gensetData(1500, 20, 100, 1000, 50, 40)},
+				{minutes: 2, data: gensetData(1500, 20, 100.5, 1020, 52, 40)},
+				{minutes: 3, data: gensetData(0, 0, 100.5, 1020, 52, 0)},
+			},
+			want: []wantRunSession{
+				{start: 0, end: 3, runHours: float(0.5), energy: float(20), fuel: float(2), peak: 40},
+			},
+		},
+		{
+			name: "consecutive sessions",
+			steps: []runStep{
+				{minutes: 0, data: gensetData(1500, 20, 100, 1000, 50, 40)},
+				{minutes: 1, data: gensetData(1500, 20, 100.5, 1010, 51, 40)},
+				{minutes: 2, data: gensetData(0, 0, 100.5, 1010, 51, 0)},
+				{minutes: 3, data: gensetData(1500, 20, 100.5, 1010, 51, 80)},
+				{minutes: 4, data: gensetData(1500, 20, 101, 1030, 53, 80)},
+				{minutes: 5, data: gensetData(0, 0, 101, 1030, 53, 0)},
+			},
+			want: []wantRunSession{
+				{start: 0, end: 2, runHours: float(0.5), energy: float(10), fuel: float(1), peak: 40},
+				{start: 3, end: 5, runHours: float(0.5), energy: float(20), fuel: float(2), peak: 80},
+			},
+		},
+		{
+			name: "mains failure trigger",
+			steps: []runStep{
+				{minutes: 0, data: gensetData(1500, 20, 100, 1000, 50, 40), statuses: map[string]bool{"MainFail": true, "AutoMode": true}},
+				{minutes: 1, data: gensetData(0, 0, 100, 1000, 50, 0), statuses: map[string]bool{"MainFail": false, "AutoMode": true}},
+			},
+			want: []wantRunSession{
+				{start: 0, end: 1, runHours: float(0), energy: float(0), fuel: float(0), peak: 40, trigger: types.RunTriggerMainsFail},
+			},
+		},
+		{
+			name: "manual trigger",
+			steps: []runStep{
+				{minutes: 0, data: gensetData(1500, 20, 100, 1000, 50, 40), statuses: map[string]bool{"MainFail": false, "AutoMode": false}},
+				{minutes: 1, data: gensetData(0, 0, 100, 1000, 50, 0), statuses: map[string]bool{"MainFail": true, "AutoMode": false}},
+			},
+			want: []wantRunSession{
+				{start: 0, end: 1, runHours: float(0), energy: float(0), fuel: float(0), peak: 40, trigger: types.RunTriggerManual},
+			},
+		},
+		{
+			name: "unknown trigger",
+			steps: []runStep{
+				{minutes: 0, data: gensetData(1500, 20, 100, 1000, 50, 40), statuses: map[string]bool{"MainFail": false, "AutoMode": true}},
+				{minutes: 1, data: gensetData(0, 0, 100, 1000, 50, 0)},
+			},
+			want: []wantRunSession{
+				{start: 0, end: 1, runHours: float(0), energy: float(0), fuel: float(0), peak: 40},
+			},
+		},
+		{
+			name: "trigger unknown at the start is set by a later message",
+			steps: []runStep{
+				{minutes: 0, data: gensetData(1500, 20, 100, 1000, 50, 40)},
+				{minutes: 1, data: gensetData(1500, 20, 100, 1000, 50, 40), statuses: map[string]bool{"MainFail": true}},
+				{minutes: 2, data: gensetData(0, 0, 100, 1000, 50, 0), statuses: map[string]bool{"MainFail": false}},
+			},
+			want: []wantRunSession{
+				{start: 0, end: 2, runHours: float(0), energy: float(0), fuel: float(0), peak: 40, trigger: types.RunTriggerMainsFail},
+			},
+		},
+		{
+			name: "devices without engine speed are not gensets",
+			steps: []runStep{
+				{minutes: 0, data: map[string]any{"GenTotalP": 20.0}},
+				{minutes: 1, data: map[string]any{"GenTotalP": 0.0}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEngine(t, app.AppConfig{RunSessions: testRunSessionsConfig})
+
+			var sessions []types.RunSession
+			for _, step := range tt.steps {
+				device := types.Device{
+					DeviceIdentifier: "genset-1",
+					Timestamp:        start.Add(time.Duration(step.minutes) * time.Minute),
+					ProcessedData:    step.data,
+					Statuses:         step.statuses,
+				}
+
+				records, updates, err := e.runSessionRecords([]types.Device{device})
+				if err != nil {
+					t.Fatalf("minute %d: %v", step.minutes, err)
+				}
+
+				for _, record := range records {
+					if record.Sink != defaultSinkName || record.Topic != "run_sessions" {
+						t.Errorf("minute %d: record to %s/%s, want %s/run_sessions", step.minutes, record.Sink, record.Topic, defaultSinkName)
+					}
+					sessions = append(sessions, runSession(t, record))
+				}
+
+				// The records are published before the next message
+				e.runSessions.apply(updates)
+			}
+
+			if len(sessions) != len(tt.want) {
+				t.Fatalf("%d run sessions, want %d: %+v", len(sessions), len(tt.want), sessions)
+			}
+
+			for i, want := range tt.want {
+				got := sessions[i]
+				wantStart := start.Add(time.Duration(want.start) * time.Minute)
+				wantEnd := start.Add(time.Duration(want.end) * time.Minute)
+
+				if !got.Start.Equal(wantStart) || !got.End.Equal(wantEnd) {
+					t.Errorf("session %d: from %v to %v, want from %v to %v", i, got.Start, got.End, wantStart, wantEnd)
+				}
+				if got.DurationSeconds != wantEnd.Sub(wantStart).Seconds() {
+					t.Errorf("session %d: DurationSeconds = %v, want %v", i, got.DurationSeconds, wantEnd.Sub(wantStart).Seconds())
+				}
+				if formatFloat(got.RunHours) != formatFloat(want.runHours) {
+					t.Errorf("session %d: RunHours = %s, want %s", i, formatFloat(got.RunHours), formatFloat(want.runHours))
+				}
+				if formatFloat(got.EnergyKWh) != formatFloat(want.energy) {
+					t.Errorf("session %d: EnergyKWh = %s, want %s", i, formatFloat(got.EnergyKWh), formatFloat(want.energy))
+				}
+				if formatFloat(got.FuelUsed) != formatFloat(want.fuel) {
+					t.Errorf("session %d: FuelUsed = %s, want %s", i, formatFloat(got.FuelUsed), formatFloat(want.fuel))
+				}
+				if got.PeakLoadPercentage != want.peak {
+					t.Errorf("session %d: PeakLoadPercentage = %v, want %v", i, got.PeakLoadPercentage, want.peak)
+				}
+				if got.Trigger != want.trigger {
+					t.Errorf("session %d: Trigger = %q, want %q", i, got.Trigger, want.trigger)
+				}
+			}
+
+			if _, open := e.runSessions.get("genset-1"); open {
+				t.Error("run session still open at the end of the messages")
+			}
+		})
+	}
+}
+
+func TestRunSessionRecordsAfterRestore(t *testing.T) {
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// The genset started before the restart
+	previous := newTestEngine(t, app.AppConfig{RunSessions: testRunSessionsConfig})
+	_, updates, err := previous.runSessionRecords([]types.Device{{DeviceIdentifier: "genset-1", Timestamp: start, ProcessedData: gensetData(1500, 20, 100, 1000, 50, 40)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous.runSessions.apply(updates)
+
+	// The persisted state goes through JSON
+	data, err := json.Marshal(previous.runSessions.snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sessions map[string]openRunSession
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		t.Fatal(err)
+	}
+
+	e := newTestEngine(t, app.AppConfig{RunSessions: testRunSessionsConfig})
+	e.runSessions.restore(sessions)
+
+	// The restored session continues rather than starting again
+	records, updates, err := e.runSessionRecords([]types.Device{{DeviceIdentifier: "genset-1", Timestamp: start.Add(3 * time.Minute), ProcessedData: gensetData(1500, 20, 100.5, 1050, 55, 40)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 || len(updates) != 1 || updates[0].started {
+		t.Fatalf("%d records and updates %+v, want the restored session to continue", len(records), updates)
+	}
+	e.runSessions.apply(updates)
+
+	// The session is reported once, from its real start
+	stop := start.Add(5 * time.Minute)
+	records, _, err = e.runSessionRecords([]types.Device{{DeviceIdentifier: "genset-1", Timestamp: stop, ProcessedData: gensetData(0, 0, 100.5, 1050, 55, 0)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("%d records, want the run session", len(records))
+	}
+
+	session := runSession(t, records[0])
+	if !session.Start.Equal(start) || !session.End.Equal(stop) {
+		t.Errorf("from %v to %v, want from %v to %v", session.Start, session.End, start, stop)
+	}
+	if formatFloat(session.RunHours) != "0.5" {
+		t.Errorf("RunHours = %s, want 0.5", formatFloat(session.RunHours))
+	}
+}
+
+func TestRunTrigger(t *testing.T) {
+	tests := []struct {
+		statuses map[string]bool
+		want     string
+	}{
+		{map[string]bool{"MainFail": true, "AutoMode": true}, types.RunTriggerMainsFail},
+		{map[string]bool{"MainFail": true}, types.RunTriggerMainsFail},
+		{map[string]bool{"MainFail": false, "AutoMode": false}, types.RunTriggerManual},
+		{map[string]bool{"AutoMode": false}, types.RunTriggerManual},
+		{map[string]bool{"MainFail": false, "AutoMode": true}, ""},
+		{map[string]bool{"MainFail": false}, ""},
+		{nil, ""},
+	}
+
+	for _, tt := range tests {
+		if got := runTrigger(tt.statuses); got != tt.want {
+			t.Errorf("runTrigger(%v) = %q, want %q", tt.statuses, got, tt.want)
+		}
+	}
+}
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		start float64
+		end   float64
+		want  *float64
+	}{
+		{100, 101.5, float(1.5)},
+		{100, 100, float(0)},
+		{100, 5, nil},
+		{0, 100, nil},
+		{100, 0, nil},
+	}
+
+	for _, tt := range tests {
+		if got := counterDelta(tt.start, tt.end); formatFloat(got) != formatFloat(tt.want) {
+			t.Errorf("counterDelta(%v, %v) = %s, want %s", tt.start, tt.end, formatFloat(got), formatFloat(tt.want))
+		}
+	}
+}
+
+func TestEndQuietRunSessions(t *testing.T) {
+	e := newTestEngine(t, app.AppConfig{RunSessions: testRunSessionsConfig})
+	sink := &testSink{}
+	e.sinks = map[string]OutputSink{defaultSinkName: sink}
+
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	now := time.Now()
+	e.runSessions.restore(map[string]openRunSession{
+		"quiet": {
+			Device:       runSessionDevice{SiteName: "Site A", DeviceName: "Genset A"},
+			Start:        start,
+			LastRunning:  start.Add(30 * time.Minute),
+			LastSeen:     now.Add(-20 * time.Minute),
+			LastRunTime:  100.5,
+			LastGenkWh:   1050,
+			LastFuelUsed: 55,
+			RunTime:      100,
+			GenkWh:       1000,
+			FuelUsed:     50,
+		},
+		"running": {Start: start, LastRunning: start, LastSeen: now.Add(-time.Minute)},
+	})
+
+	// Gensets are not quiet before the baseline
+	e.endQuietRunSessions(now.Add(-time.Minute))
+	if len(sink.published) != 0 {
+		t.Fatalf("%d records, want none since the baseline", len(sink.published))
+	}
+
+	e.endQuietRunSessions(now.Add(-time.Hour))
+	if len(sink.published) != 1 {
+		t.Fatalf("%d records, want the quiet run session", len(sink.published))
+	}
+
+	session := runSession(t, sink.published[0])
+	if session.DeviceIdentifier != "quiet" || session.SiteName != "Site A" || session.DeviceName != "Genset A" {
+		t.Errorf("run session of %s at %s, want quiet at Site A", session.DeviceName, session.SiteName)
+	}
+	if !session.Start.Equal(start) || !session.End.Equal(start.Add(30*time.Minute)) {
+		t.Errorf("from %v to %v, want to end at the last running message", session.Start, session.End)
+	}
+	if formatFloat(session.RunHours) != "0.5" || formatFloat(session.EnergyKWh) != "50" || formatFloat(session.FuelUsed) != "5" {
+		t.Errorf("counters %s, %s and %s, want 0.5, 50 and 5", formatFloat(session.RunHours), formatFloat(session.EnergyKWh), formatFloat(session.FuelUsed))
+	}
+
+	if _, open := e.runSessions.get("quiet"); open {
+		t.Error("quiet run session still open")
+	}
+	if _, open := e.runSessions.get("running"); !open {
+		t.Error("running session ended")
+	}
+
+	// A running message published while the session ended does not reopen it
+	e.runSessions.apply([]runSessionUpdate{{device: "quiet", session: &openRunSession{Start: start}}})
+	if _, open := e.runSessions.get("quiet"); open {
+		t.Error("ended run session reopened by an update")
+	}
+}
@@ -0,0 +1,354 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/johandrevandeventer/dse-worker/internal/config/app"
+	"go.uber.org/zap"
+)
+
+// defaultSinkName is the sink of routes that do not name one
+const defaultSinkName = "kafka"
+
+// Record is a message published to a topic of an output sink
+type Record struct {
+	Sink  string // Name of the output sink
+	Topic string
+	Value []byte // Serialized payload
+}
+
+// OutputSink publishes records to a destination
+type OutputSink interface {
+	// Publish publishes records and returns once they are delivered
+	Publish(ctx context.Context, records []Record) error
+	// Close flushes and releases the sink
+	Close() error
+}
+
+// NewOutputSink creates an output sink from its configuration.
+// A Kafka sink gets a producer pool of its own, created from the Kafka configuration.
+func NewOutputSink(ctx context.Context, cfg app.SinkConfig, kafkaCfg app.KafkaConfig, logger *zap.Logger) (OutputSink, error) {
+	switch cfg.Type {
+	case app.SinkTypeKafka:
+		pool, err := newKafkaProducerPool(ctx, kafkaCfg, logger)
+		if err != nil {
+			return nil, err
+		}
+		return &kafkaSink{pool: func() *kafkaProducerPool { return pool }, owned: true}, nil
+	case app.SinkTypeFile:
+		return newFileSink(cfg.FilePath)
+	case app.SinkTypeStdout:
+		return &writerSink{w: os.Stdout}, nil
+	case app.SinkTypeWebhook:
+		return newWebhookSink(cfg)
+	}
+
+	return nil, fmt.Errorf("unknown type %q of sink %s", cfg.Type, cfg.Name)
+}
+
+// kafkaSink publishes records to Kafka
+type kafkaSink struct {
+	pool  func() *kafkaProducerPool
+	owned bool // The sink closes the pool
+}
+
+func (s *kafkaSink) Publish(ctx context.Context, records []Record) error {
+	pool := s.pool()
+	if pool == nil {
+		return fmt.Errorf("kafka producer pool not available")
+	}
+
+	return pool.Produce(ctx, records)
+}
+
+func (s *kafkaSink) Close() error {
+	if pool := s.pool(); s.owned && pool != nil {
+		pool.Close()
+	}
+
+	return nil
+}
+
+// sinkLine is the JSON representation of a record written by the file, stdout and webhook sinks
+type sinkLine struct {
+	Topic   string          `json:"topic"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func sinkLines(records []Record) []sinkLine {
+	lines := make([]sinkLine, len(records))
+	for i, r := range records {
+		lines[i] = sinkLine{Topic: r.Topic, Payload: r.Value}
+	}
+
+	return lines
+}
+
+// writerSink writes records as JSON lines
+type writerSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *writerSink) Publish(ctx context.Context, records []Record) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, line := range sinkLines(records) {
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.w.Write(buf.Bytes())
+	return err
+}
+
+func (s *writerSink) Close() error {
+	return nil
+}
+
+// fileSink appends records to a JSON lines file
+type fileSink struct {
+	writerSink
+	file *os.File
+}
+
+func newFileSink(filePath string) (*fileSink, error) {
+	if filePath == "" {
+		return nil, fmt.Errorf("file sink requires a file path")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o770); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o660)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileSink{writerSink: writerSink{w: file}, file: file}, nil
+}
+
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
+
+// webhookSink posts records to an HTTP endpoint as a JSON array
+type webhookSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newWebhookSink(cfg app.SinkConfig) (*webhookSink, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("webhook sink requires a URL")
+	}
+
+	return &webhookSink{
+		url:     cfg.URL,
+		headers: cfg.Headers,
+		client:  &http.Client{Timeout: time.Duration(cfg.TimeoutMs) * time.Millisecond},
+	}, nil
+}
+
+func (s *webhookSink) Publish(ctx context.Context, records []Record) error {
+	body, err := json.Marshal(sinkLines(records))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range s.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s responded with status %d", s.url, resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (s *webhookSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// startSinks creates the configured output sinks. Kafka sinks share the producer pool of the engine.
+func (e *Engine) startSinks() error {
+	e.sinks = make(map[string]OutputSink)
+
+	for _, sinkCfg := range e.cfg.App.Sinks {
+		if _, ok := e.sinks[sinkCfg.Name]; ok {
+			return fmt.Errorf("duplicate sink %s", sinkCfg.Name)
+		}
+
+		if sinkCfg.Type == app.SinkTypeKafka {
+			e.sinks[sinkCfg.Name] = &kafkaSink{pool: e.kafkaProducerPool.Load}
+			continue
+		}
+
+		sink, err := NewOutputSink(e.workCtx, sinkCfg, e.cfg.App.Kafka, e.logger)
+		if err != nil {
+			return fmt.Errorf("failed to create sink %s: %w", sinkCfg.Name, err)
+		}
+
+		e.sinks[sinkCfg.Name] = sink
+	}
+
+	// Dead letters are published to Kafka, whether or not a Kafka sink is configured
+	if _, ok := e.sinks[defaultSinkName]; !ok {
+		e.sinks[defaultSinkName] = &kafkaSink{pool: e.kafkaProducerPool.Load}
+	}
+
+	for _, route := range e.cfg.App.Routes {
+		if _, ok := e.sinks[routeSink(route)]; !ok {
+			return fmt.Errorf("route %s refers to unknown sink %s", route.Name, routeSink(route))
+		}
+	}
+
+	if connectivityCfg := e.cfg.App.Connectivity; connectivityCfg.Enabled {
+		if _, ok := e.sinks[connectivityCfg.Sink]; !ok {
+			return fmt.Errorf("connectivity events refer to unknown sink %s", connectivityCfg.Sink)
+		}
+	}
+
+	if alarmsCfg := e.cfg.App.Alarms; alarmsCfg.Enabled {
+		if _, ok := e.sinks[alarmsCfg.Sink]; !ok {
+			return fmt.Errorf("alarm events refer to unknown sink %s", alarmsCfg.Sink)
+		}
+	}
+
+	if runSessionsCfg := e.cfg.App.RunSessions; runSessionsCfg.Enabled {
+		if _, ok := e.sinks[runSessionsCfg.Sink]; !ok {
+			return fmt.Errorf("run sessions refer to unknown sink %s", runSessionsCfg.Sink)
+		}
+	}
+
+	return nil
+}
+
+// closeSinks closes the output sinks
+func (e *Engine) closeSinks() {
+	for name, sink := range e.sinks {
+		if err := sink.Close(); err != nil {
+			e.logger.Error("Failed to close sink", zap.String("sink", name), zap.Error(err))
+		}
+	}
+}
+
+// sendMessage sends a message to a topic of the Kafka sink and waits for its delivery
+func (e *Engine) sendMessage(topic string, message []byte) error {
+	return e.sendRecords([]Record{{Sink: defaultSinkName, Topic: topic, Value: message}}, nil)
+}
+
+// sendRecords sends records to their output sinks and waits for their delivery.
+// Records are sent to each sink in a single call, which is a single transaction for Kafka in transactional mode.
+// Delivery is atomic per sink only: when a sink fails, the others are still sent to. Sinks in delivered are skipped,
+// and sinks that succeed are added to it, so that sending the records again only retries the sinks that failed.
+func (e *Engine) sendRecords(records []Record, delivered map[string]bool) error {
+	var sinkNames []string
+	bySink := make(map[string][]Record)
+	for _, r := range records {
+		if delivered[r.Sink] {
+			continue
+		}
+		if _, ok := bySink[r.Sink]; !ok {
+			sinkNames = append(sinkNames, r.Sink)
+		}
+		bySink[r.Sink] = append(bySink[r.Sink], r)
+	}
+
+	var errs []error
+	for _, name := range sinkNames {
+		if err := e.publishToSink(name, bySink[name]); err != nil {
+			errs = append(errs, fmt.Errorf("sink %s: %w", name, err))
+			continue
+		}
+
+		if delivered != nil {
+			delivered[name] = true
+		}
+
+		e.health.lastPublished.Store(time.Now().UnixNano())
+		e.stats.sent(bySink[name])
+	}
+
+	return errors.Join(errs...)
+}
+
+// publishToSink publishes records to an output sink.
+// Failed attempts are retried with exponential backoff, up to the configured number of retries.
+func (e *Engine) publishToSink(name string, records []Record) error {
+	sink, ok := e.sinks[name]
+	if !ok {
+		return fmt.Errorf("unknown sink")
+	}
+
+	kafkaCfg := e.cfg.App.Kafka
+
+	operation := func() error {
+		ctx := e.workCtx
+		if kafkaCfg.SendTimeoutMs > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(e.workCtx, time.Duration(kafkaCfg.SendTimeoutMs)*time.Millisecond)
+			defer cancel()
+		}
+
+		result := "success"
+		err := sink.Publish(ctx, records)
+		if err != nil {
+			result = "failure"
+		}
+
+		for _, r := range records {
+			sinkDeliveries.WithLabelValues(name, r.Topic, result).Inc()
+		}
+
+		return err
+	}
+
+	retryBackoff := backoff.NewExponentialBackOff()
+	if kafkaCfg.ProducerRetryBackoffMs > 0 {
+		retryBackoff.InitialInterval = time.Duration(kafkaCfg.ProducerRetryBackoffMs) * time.Millisecond
+	}
+	if kafkaCfg.ProducerRetryMaxBackoffMs > 0 {
+		retryBackoff.MaxInterval = time.Duration(kafkaCfg.ProducerRetryMaxBackoffMs) * time.Millisecond
+	}
+	retryBackoff.MaxElapsedTime = 0 // Bounded by the number of retries instead
+
+	return backoff.RetryNotify(operation, backoff.WithContext(backoff.WithMaxRetries(retryBackoff, uint64(max(kafkaCfg.ProducerMaxRetries, 0))), e.workCtx), func(err error, duration time.Duration) {
+		e.logger.Warn("Failed to send message, retrying...", zap.String("sink", name), zap.Int("records", len(records)), zap.Error(err), zap.Duration("retry_after", duration))
+	})
+}
@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/johandrevandeventer/dse-worker/internal/config/app"
+)
+
+// testSink records the records published to it, and fails while err is set
+type testSink struct {
+	published []Record
+	err       error
+}
+
+func (s *testSink) Publish(ctx context.Context, records []Record) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	s.published = append(s.published, records...)
+	return nil
+}
+
+func (s *testSink) Close() error {
+	return nil
+}
+
+func TestSendRecordsRetriesFailedSinks(t *testing.T) {
+	e := newTestEngine(t, app.AppConfig{Kafka: app.KafkaConfig{ProducerMaxRetries: 1, ProducerRetryBackoffMs: 1, ProducerRetryMaxBackoffMs: 1}})
+	kafkaSink := &testSink{}
+	webhookSink := &testSink{err: errors.New("webhook unavailable")}
+	e.sinks = map[string]OutputSink{"kafka": kafkaSink, "webhook": webhookSink}
+
+	records := []Record{
+		{Sink: "kafka", Topic: "data", Value: []byte("1")},
+		{Sink: "webhook", Topic: "data", Value: []byte("1")},
+		{Sink: "kafka", Topic: "events", Value: []byte("2")},
+	}
+
+	delivered := make(map[string]bool)
+	if err := e.sendRecords(records, delivered); err == nil {
+		t.Fatal("sendRecords() succeeded with a failing sink")
+	}
+	if len(kafkaSink.published) != 2 {
+		t.Errorf("%d records published to the kafka sink, want 2 despite the failing webhook", len(kafkaSink.published))
+	}
+	if !delivered["kafka"] || delivered["webhook"] {
+		t.Errorf("delivered = %v, want only kafka", delivered)
+	}
+
+	// The retry only sends to the sink that failed
+	webhookSink.err = nil
+	if err := e.sendRecords(records, delivered); err != nil {
+		t.Fatalf("sendRecords(): %v", err)
+	}
+	if len(kafkaSink.published) != 2 {
+		t.Errorf("%d records published to the kafka sink after the retry, want 2", len(kafkaSink.published))
+	}
+	if len(webhookSink.published) != 1 {
+		t.Errorf("%d records published to the webhook sink, want 1", len(webhookSink.published))
+	}
+
+	// Without a delivered set, every sink is sent to
+	if err := e.sendRecords(records, nil); err != nil {
+		t.Fatalf("sendRecords(): %v", err)
+	}
+	if len(kafkaSink.published) != 4 || len(webhookSink.published) != 2 {
+		t.Errorf("%d and %d records published, want 4 and 2", len(kafkaSink.published), len(webhookSink.published))
+	}
+}
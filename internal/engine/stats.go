@@ -0,0 +1,216 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// persistedStats is the form of the runtime statistics in the persisted app state
+type persistedStats struct {
+	Since             time.Time                 `json:"since"` // When counting started, counters carry over across restarts
+	UpdatedAt         time.Time                 `json:"updated_at"`
+	MessagesConsumed  uint64                    `json:"messages_consumed"`
+	MessagesProcessed uint64                    `json:"messages_processed"`
+	MessagesPublished uint64                    `json:"messages_published"`
+	Errors            map[string]uint64         `json:"errors"`    // By error category
+	Publishes         map[string]uint64         `json:"publishes"` // Records by topic
+	LastMessageTime   *time.Time                `json:"last_message_time,omitempty"`
+	Devices           map[string]DeviceActivity `json:"devices"` // Last seen and message count by device
+}
+
+// runtimeStats counts the work of the engine for the persisted app state
+type runtimeStats struct {
+	mu                sync.Mutex
+	since             time.Time
+	messagesConsumed  uint64
+	messagesProcessed uint64
+	messagesPublished uint64
+	errors            map[string]uint64
+	publishes         map[string]uint64
+	lastMessageTime   time.Time
+}
+
+func newRuntimeStats() *runtimeStats {
+	return &runtimeStats{
+		since:     time.Now(),
+		errors:    make(map[string]uint64),
+		publishes: make(map[string]uint64),
+	}
+}
+
+// consumed counts a consumed message
+func (s *runtimeStats) consumed(at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messagesConsumed++
+	s.lastMessageTime = at
+}
+
+// processed counts a message the worker processed
+func (s *runtimeStats) processed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messagesProcessed++
+}
+
+// published counts a message whose devices were all published
+func (s *runtimeStats) published() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messagesPublished++
+}
+
+// failed counts an error of a category
+func (s *runtimeStats) failed(category string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.errors[category]++
+}
+
+// sent counts records published to their topics
+func (s *runtimeStats) sent(records []Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range records {
+		s.publishes[r.Topic]++
+	}
+}
+
+// snapshot returns the statistics in their persisted form
+func (s *runtimeStats) snapshot(devices []DeviceActivity) persistedStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := persistedStats{
+		Since:             s.since,
+		UpdatedAt:         time.Now(),
+		MessagesConsumed:  s.messagesConsumed,
+		MessagesProcessed: s.messagesProcessed,
+		MessagesPublished: s.messagesPublished,
+		Errors:            maps.Clone(s.errors),
+		Publishes:         maps.Clone(s.publishes),
+		Devices:           make(map[string]DeviceActivity, len(devices)),
+	}
+
+	if !s.lastMessageTime.IsZero() {
+		lastMessageTime := s.lastMessageTime
+		stats.LastMessageTime = &lastMessageTime
+	}
+
+	for _, device := range devices {
+		stats.Devices[device.Device] = device
+	}
+
+	return stats
+}
+
+// restore continues counting from persisted statistics
+func (s *runtimeStats) restore(stats persistedStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !stats.Since.IsZero() {
+		s.since = stats.Since
+	}
+	s.messagesConsumed += stats.MessagesConsumed
+	s.messagesProcessed += stats.MessagesProcessed
+	s.messagesPublished += stats.MessagesPublished
+	for category, n := range stats.Errors {
+		s.errors[category] += n
+	}
+	for topic, n := range stats.Publishes {
+		s.publishes[topic] += n
+	}
+	if stats.LastMessageTime != nil && stats.LastMessageTime.After(s.lastMessageTime) {
+		s.lastMessageTime = *stats.LastMessageTime
+	}
+}
+
+// restoreStats continues the runtime statistics of the previous run from the persist file.
+// It must run before the app state is reset.
+func (e *Engine) restoreStats() {
+	var stats persistedStats
+	found, err := readPersistedValue(e.cfg.App.Runtime.PersistFilePath, "app.stats", &stats)
+	if err != nil {
+		e.logger.Error("Failed to restore runtime statistics, starting from zero", zap.Error(err))
+		return
+	}
+
+	if !found {
+		return
+	}
+
+	e.stats.restore(stats)
+
+	devices := make([]DeviceActivity, 0, len(stats.Devices))
+	for _, device := range stats.Devices {
+		devices = append(devices, device)
+	}
+	e.devices.restore(devices)
+
+	e.verboseDebug("Runtime statistics restored", zap.Time("since", stats.Since), zap.Uint64("messages_consumed", stats.MessagesConsumed))
+}
+
+// saveStats writes the runtime statistics to the persisted app state
+func (e *Engine) saveStats() {
+	e.statePersister.Set("app.stats", e.stats.snapshot(e.devices.list()))
+}
+
+// WatchRuntimeStats saves the runtime statistics periodically
+func (e *Engine) WatchRuntimeStats() {
+	interval := time.Duration(max(e.cfg.App.Stats.FlushIntervalSeconds, 1)) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+			e.saveStats()
+		}
+	}
+}
+
+// readPersistedValue reads a value of the persist file into target. Keys are nested by dots, like those set on the persister.
+// It returns false if the file or the key does not exist.
+func readPersistedValue(filePath string, key string, target any) (bool, error) {
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read persist file: %w", err)
+	}
+
+	var value json.RawMessage = data
+	for _, part := range strings.Split(key, ".") {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(value, &object); err != nil {
+			return false, fmt.Errorf("failed to decode persist file: %w", err)
+		}
+
+		var ok bool
+		if value, ok = object[part]; !ok {
+			return false, nil
+		}
+	}
+
+	if err := json.Unmarshal(value, target); err != nil {
+		return false, fmt.Errorf("failed to decode %s: %w", key, err)
+	}
+
+	return true, nil
+}
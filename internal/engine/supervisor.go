@@ -0,0 +1,156 @@
+package engine
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	coreutils "github.com/johandrevandeventer/dse-worker/utils"
+	"go.uber.org/zap"
+)
+
+// Kafka clients
+const (
+	kafkaClientProducer = "producer"
+	kafkaClientConsumer = "consumer"
+)
+
+// Kafka connection states
+const (
+	connectionStateWaiting   = "waiting"
+	connectionStateConnected = "connected"
+)
+
+// connectionState is the state of the connection of a Kafka client
+type connectionState struct {
+	State     string `json:"state"`
+	LastError string `json:"last_error,omitempty"`
+	Since     string `json:"since"`
+}
+
+// connectionStates tracks the connection state of the Kafka clients
+type connectionStates struct {
+	mu     sync.Mutex
+	states map[string]connectionState
+}
+
+func newConnectionStates() *connectionStates {
+	return &connectionStates{
+		states: make(map[string]connectionState),
+	}
+}
+
+// get returns the connection state of a client, and false if it never connected or tried to
+func (c *connectionStates) get(client string) (connectionState, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state, ok := c.states[client]
+	return state, ok
+}
+
+// waiting reports whether a Kafka client is waiting for its brokers
+func (c *connectionStates) waiting() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, state := range c.states {
+		if state.State == connectionStateWaiting {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setConnectionState records the connection state of a Kafka client.
+// A change is written to the connections log and the persisted app state, which is degraded while a client is waiting.
+func (e *Engine) setConnectionState(client string, state string, err error) {
+	c := e.connections
+	c.mu.Lock()
+
+	previous, ok := c.states[client]
+	if ok && previous.State == state && (err == nil || previous.LastError == err.Error()) {
+		c.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	current := connectionState{State: state, Since: now.Format(time.RFC3339)}
+	if ok && previous.State == state {
+		current.Since = previous.Since
+	}
+	if err != nil {
+		current.LastError = err.Error()
+	}
+	c.states[client] = current
+
+	status := "running"
+	persisted := make(map[string]any, len(c.states))
+	for name, s := range c.states {
+		persisted[name] = map[string]any{"state": s.State, "last_error": s.LastError, "since": s.Since}
+		if s.State == connectionStateWaiting {
+			status = "waiting_for_kafka"
+		}
+	}
+	c.mu.Unlock()
+
+	if ok && previous.State == connectionStateWaiting && status != "waiting_for_kafka" {
+		e.health.kafkaResumed.Store(now.UnixNano())
+	}
+
+	e.statePersister.Set("app.kafka", persisted)
+	if e.ctx.Err() == nil {
+		e.statePersister.Set("app.status", status)
+	}
+
+	if state == connectionStateConnected {
+		kafkaConnected.WithLabelValues(client).Set(1)
+	} else {
+		kafkaConnected.WithLabelValues(client).Set(0)
+	}
+
+	// Only log state changes, not every retry
+	if ok && previous.State == state {
+		return
+	}
+
+	message := fmt.Sprintf("%s: Kafka %s %s\n", now.Format(time.RFC3339), client, state)
+	if err != nil {
+		message = fmt.Sprintf("%s: Kafka %s %s: %v\n", now.Format(time.RFC3339), client, state, err)
+	}
+	coreutils.WriteToLogFile(e.connectionsLogFilePath, message)
+
+	if state == connectionStateConnected {
+		e.logger.Info("Kafka client connected", zap.String("client", client))
+	} else {
+		e.logger.Warn("Waiting for Kafka", zap.String("client", client), zap.Error(err))
+	}
+}
+
+// superviseKafka runs connect until it succeeds, retrying with exponential backoff while the engine runs.
+// It returns false if the engine stopped before connect succeeded.
+func (e *Engine) superviseKafka(client string, connect func() error) bool {
+	kafkaCfg := e.cfg.App.Kafka
+
+	reconnectBackoff := backoff.NewExponentialBackOff()
+	if kafkaCfg.ReconnectBackoffMs > 0 {
+		reconnectBackoff.InitialInterval = time.Duration(kafkaCfg.ReconnectBackoffMs) * time.Millisecond
+	}
+	if kafkaCfg.ReconnectMaxBackoffMs > 0 {
+		reconnectBackoff.MaxInterval = time.Duration(kafkaCfg.ReconnectMaxBackoffMs) * time.Millisecond
+	}
+	reconnectBackoff.MaxElapsedTime = 0 // Keep trying until the engine stops
+
+	err := backoff.RetryNotify(connect, backoff.WithContext(reconnectBackoff, e.ctx), func(err error, duration time.Duration) {
+		e.setConnectionState(client, connectionStateWaiting, err)
+		e.logger.Warn("Failed to connect Kafka client, retrying...", zap.String("client", client), zap.Error(err), zap.Duration("retry_after", duration))
+	})
+	if err != nil {
+		return false
+	}
+
+	e.setConnectionState(client, connectionStateConnected, nil)
+	return true
+}
@@ -2,9 +2,15 @@ package engine
 
 import (
 	"encoding/json"
-	"strings"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/johandrevandeventer/dse-worker/internal/flags"
+	"github.com/johandrevandeventer/dse-worker/internal/workers"
 	dseworker "github.com/johandrevandeventer/dse-worker/internal/workers/dse_worker"
 	"github.com/johandrevandeventer/dse-worker/internal/workers/types"
 	"github.com/johandrevandeventer/kafkaclient/payload"
@@ -12,12 +18,33 @@ import (
 	"go.uber.org/zap"
 )
 
+// shard processes the messages of the devices hashed to it, in arrival order
+type shard struct {
+	id                  int
+	queue               chan *message
+	worker              *dseworker.Worker
+	workersLogger       *zap.Logger
+	kafkaProducerLogger *zap.Logger
+}
+
 func (e *Engine) startWorker() {
-	e.logger.Info("Starting DSE workers")
+	poolSize := max(e.cfg.App.Workers.PoolSize, 1)
+	queueDepth := max(e.cfg.App.Workers.QueueDepth, 1)
+
+	// The shards are added under the lock the drain takes, so that none are added once it waits for them
+	e.shardsMu.Lock()
+	if e.ctx.Err() != nil {
+		e.shardsMu.Unlock()
+		return
+	}
+	e.shardsWg.Add(poolSize)
+	e.shardsMu.Unlock()
+
+	e.logger.Info("Starting DSE workers", zap.Int("pool_size", poolSize), zap.Int("queue_depth", queueDepth))
 
 	var workersLogger *zap.Logger
 	var kafkaProducerLogger *zap.Logger
-	if flags.FlagWorkersLogging {
+	if flags.FlagWorkersLogging || e.cfg.App.Features.WorkersLogging {
 		workersLogger = logging.GetLogger("workers")
 		kafkaProducerLogger = logging.GetLogger("kafka.producer")
 	} else {
@@ -25,139 +52,278 @@ func (e *Engine) startWorker() {
 		kafkaProducerLogger = zap.NewNop()
 	}
 
+	workerPoolSize.Set(float64(poolSize))
+	workerQueueDepth.Set(float64(queueDepth))
+
+	// Start a worker per shard
+	shards := make([]*shard, poolSize)
+	for i := range shards {
+		shards[i] = &shard{
+			id:                  i,
+			queue:               make(chan *message, queueDepth),
+			worker:              dseworker.NewWorker(workersLogger),
+			workersLogger:       workersLogger,
+			kafkaProducerLogger: kafkaProducerLogger,
+		}
+
+		go func(sh *shard) {
+			defer e.shardsWg.Done()
+			e.runShard(sh)
+		}(shards[i])
+	}
+
+	// Closing the queues lets the shards finish the queued messages and stop
+	defer func() {
+		for _, sh := range shards {
+			close(sh.queue)
+		}
+	}()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	// Dispatch messages to the shard of their device, so that messages of a device stay in order.
+	// While paused no messages are taken, which makes the consumer pause its partitions.
+	e.health.heartbeat("dispatcher")
 	for {
+		inputCh := e.inputCh
+		if e.paused.Load() {
+			inputCh = nil
+		}
+
 		select {
 		case <-e.ctx.Done(): // Handle context cancellation (e.g., Ctrl+C)
 			e.logger.Info("Stopping worker due to context cancellation")
 			return
-		case data, ok := <-e.kafkaConsumer.GetOutputChannel():
-			if !ok { // Channel is closed
-				e.logger.Info("Kafka consumer output channel closed, stopping worker")
+		case <-heartbeat.C:
+			e.health.heartbeat("dispatcher")
+		case <-e.pauseCh:
+		case msg := <-inputCh:
+			e.health.heartbeat("dispatcher")
+			consumedAt := time.Now()
+			e.health.lastConsumed.Store(consumedAt.UnixNano())
+			e.stats.consumed(consumedAt)
+			messagesConsumed.Inc()
+
+			// Messages that cannot be deserialized share a shard, which hands them to their error policy
+			key := ""
+			msg.payload, msg.payloadErr = payload.Deserialize(msg.value)
+			if msg.payloadErr == nil {
+				key = dseworker.MessageKey(*msg.payload)
+			}
+			sh := shards[shardIndex(key, poolSize)]
+
+			select {
+			case sh.queue <- msg:
+				shardBacklog.WithLabelValues(strconv.Itoa(sh.id)).Set(float64(len(sh.queue)))
+			case <-e.ctx.Done():
+				e.logger.Info("Stopping worker due to context cancellation")
+				return
+			}
+		}
+	}
+}
+
+// runShard processes the messages queued on a shard until its queue is closed.
+// Once the drain is cancelled, the remaining messages are left uncommitted to be redelivered.
+func (e *Engine) runShard(sh *shard) {
+	name := "shard-" + strconv.Itoa(sh.id)
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	e.health.heartbeat(name)
+	for {
+		select {
+		case <-heartbeat.C:
+			e.health.heartbeat(name)
+		case msg, ok := <-sh.queue:
+			if !ok {
 				return
 			}
 
-			deserializedData, err := payload.Deserialize(data)
-			if err != nil {
-				e.logger.Error("Failed to deserialize data", zap.Error(err))
+			shardBacklog.WithLabelValues(strconv.Itoa(sh.id)).Set(float64(len(sh.queue)))
+			if e.workCtx.Err() != nil {
 				continue
 			}
 
-			worker := dseworker.NewWorker(workersLogger)
+			if e.processMessage(sh, msg) {
+				// Only commit the offset once the message is published, dropped or dead-lettered
+				msg.ack()
+				messagesAcked.Inc()
+			}
+			e.health.heartbeat(name)
+		}
+	}
+}
+
+// processMessage runs the worker on a message and publishes the resulting device data.
+// It returns true when the message has been handled and its offset may be committed.
+func (e *Engine) processMessage(sh *shard, msg *message) bool {
+	if msg.payloadErr != nil {
+		_, handled := e.handleWorkerError(payload.Payload{Message: msg.value}, &workers.WorkerError{
+			Worker:   dseworker.WorkerTitle,
+			Category: workers.CategoryDeserialize,
+			Err:      fmt.Errorf("failed to deserialize data: %w", msg.payloadErr),
+		}, 1)
+		return handled
+	}
+	deserializedData := msg.payload
+
+	// Skip messages that are redelivered after they were published
+	if e.isDuplicatePayload(deserializedData.ID) {
+		sh.workersLogger.Debug("Skipping duplicate message", zap.String("id", deserializedData.ID.String()))
+		return true
+	}
 
-			messageInfo, err := worker.RunWorker(data)
+	processingStart := time.Now()
+
+	messageInfo, handled := e.runWorker(sh.worker, *deserializedData)
+	if messageInfo == nil {
+		return handled
+	}
+
+	e.stats.processed()
+	messageLag.WithLabelValues(messageInfo.Decoder).Observe(processingStart.Sub(deserializedData.MessageTimestamp).Seconds())
+	defer func() {
+		processingLatency.WithLabelValues(messageInfo.Decoder).Observe(time.Since(processingStart).Seconds())
+	}()
+
+	for _, device := range messageInfo.Devices {
+		e.devices.seen(device, processingStart)
+		e.connectivity.seen(device, processingStart)
+	}
+
+	messageInfo.Devices = e.filterDuplicateDevices(messageInfo.Devices)
+	for _, device := range messageInfo.Devices {
+		messagesProcessed.WithLabelValues(messageInfo.Decoder, device.CustomerName).Inc()
+	}
+
+	// Retries only send to the sinks that failed
+	delivered := make(map[string]bool)
+	for attempt := 1; ; attempt++ {
+		err := e.publishDevices(sh, *deserializedData, messageInfo, delivered)
+		if err == nil {
+			e.rememberPublished(deserializedData.ID, messageInfo.Devices)
+			e.stats.published()
+			return true
+		}
+
+		retry, handled := e.handleWorkerError(*deserializedData, &workers.WorkerError{
+			Worker:   dseworker.WorkerTitle,
+			Decoder:  messageInfo.Decoder,
+			Category: workers.CategoryPublish,
+			Err:      err,
+		}, attempt)
+		if !retry {
+			return handled
+		}
+	}
+}
+
+// publishDevices publishes the raw and processed data of every device in a message, to the sinks not yet delivered to
+func (e *Engine) publishDevices(sh *shard, p payload.Payload, messageInfo *types.MessageInfo, delivered map[string]bool) error {
+	var records []Record
+	for _, device := range messageInfo.Devices {
+		routed := 0
+		for _, state := range []string{types.StatePre, types.StatePost} {
+			dataStruct := device.DataStruct(state)
+
+			serializedPayload, err := SerializeDataStruct(p.ID, dataStruct)
 			if err != nil {
-				if strings.Contains(err.Error(), "controller is ignored") {
-					errorSplit := strings.Split(err.Error(), "controller is ignored: ")
-					controllerID := errorSplit[1]
-					e.logger.Warn("Controller is ignored", zap.String("controllerID", controllerID))
-				} else if strings.Contains(err.Error(), "device is ignored") {
-					errorSplit := strings.Split(err.Error(), "device is ignored: ")
-					deviceID := errorSplit[1]
-					e.logger.Warn("Device is ignored", zap.String("deviceID", deviceID))
-				} else if strings.Contains(err.Error(), "device not found") {
-					errorSplit := strings.Split(err.Error(), "device not found: ")
-					deviceID := errorSplit[1]
-					e.logger.Warn("Device not found", zap.String("deviceID", deviceID))
-				} else {
-					e.logger.Error("Processing failed", zap.Error(err))
-				}
-				continue
+				return err
 			}
 
-			for _, device := range messageInfo.Devices {
-				rawDataStruct := &types.DataStruct{
-					State:                "Pre",
-					CustomerID:           device.CustomerID,
-					CustomerName:         device.CustomerName,
-					SiteID:               device.SiteID,
-					SiteName:             device.SiteName,
-					Controller:           device.Controller,
-					DeviceType:           device.DeviceType,
-					ControllerIdentifier: device.ControllerIdentifier,
-					DeviceName:           device.DeviceName,
-					DeviceIdentifier:     device.DeviceIdentifier,
-					Data:                 device.RawData,
-					Timestamp:            device.Timestamp,
-				}
-
-				processedDataStruct := &types.DataStruct{
-					State:                "Post",
-					CustomerID:           device.CustomerID,
-					CustomerName:         device.CustomerName,
-					SiteID:               device.SiteID,
-					SiteName:             device.SiteName,
-					Controller:           device.Controller,
-					DeviceType:           device.DeviceType,
-					ControllerIdentifier: device.ControllerIdentifier,
-					DeviceName:           device.DeviceName,
-					DeviceIdentifier:     device.DeviceIdentifier,
-					Data:                 device.ProcessedData,
-					Timestamp:            device.Timestamp,
-				}
-
-				serializedRawData, err := json.Marshal(rawDataStruct)
-				if err != nil {
-					workersLogger.Error("Failed to serialize raw data", zap.Error(err))
-					return
-				}
-
-				serializedProcessedData, err := json.Marshal(processedDataStruct)
-				if err != nil {
-					workersLogger.Error("Failed to serialize processed data", zap.Error(err))
-					return
-				}
-
-				rp := payload.Payload{
-					ID:               deserializedData.ID,
-					Message:          serializedRawData,
-					MessageTimestamp: rawDataStruct.Timestamp,
-				}
-
-				pp := payload.Payload{
-					ID:               deserializedData.ID,
-					Message:          serializedProcessedData,
-					MessageTimestamp: processedDataStruct.Timestamp,
-				}
-
-				serializedRp, err := rp.Serialize()
-				if err != nil {
-					workersLogger.Error("Failed to serialize raw payload", zap.Error(err))
-					return
-				}
-
-				serializedPp, err := pp.Serialize()
-				if err != nil {
-					workersLogger.Error("Failed to serialize processed payload", zap.Error(err))
-					return
-				}
-
-				influxdb_kafka_topic := "rubicon_kafka_influxdb"
-				kodelabs_kafka_topic := "rubicon_kafka_kodelabs"
-
-				if flags.FlagEnvironment == "development" {
-					influxdb_kafka_topic = "rubicon_kafka_influxdb_development"
-					kodelabs_kafka_topic = "rubicon_kafka_kodelabs_development"
-				}
-
-				// Send the processed data to the Kafka producer
-				err = e.kafkaProducerPool.SendMessage(e.ctx, influxdb_kafka_topic, serializedRp)
-				if err != nil {
-					kafkaProducerLogger.Error("Failed to send raw data to Kafka", zap.Error(err))
-					return
-				}
-
-				err = e.kafkaProducerPool.SendMessage(e.ctx, influxdb_kafka_topic, serializedPp)
-				if err != nil {
-					kafkaProducerLogger.Error("Failed to send processed data to Kafka", zap.Error(err))
-					return
-				}
-
-				err = e.kafkaProducerPool.SendMessage(e.ctx, kodelabs_kafka_topic, serializedPp)
-				if err != nil {
-					kafkaProducerLogger.Error("Failed to send processed data to Kafka", zap.Error(err))
-					return
-				}
+			// Route the data to its sink topics
+			for _, target := range e.routeTargets(dataStruct) {
+				records = append(records, Record{Sink: target.sink, Topic: target.topic, Value: serializedPayload})
+				routed++
 			}
 		}
+
+		if routed == 0 {
+			sh.workersLogger.Warn("No route matches device data", zap.String("customer", device.CustomerName), zap.String("site", device.SiteName), zap.String("deviceType", device.DeviceType))
+		}
+	}
+
+	// Alarm events are sent with the data, so that they are retried and committed with it
+	alarmRecords, alarmTransitions, err := e.alarmRecords(messageInfo.Devices)
+	if err != nil {
+		return err
+	}
+	records = append(records, alarmRecords...)
+
+	// So are run sessions
+	runSessionRecords, runSessionUpdates, err := e.runSessionRecords(messageInfo.Devices)
+	if err != nil {
+		return err
+	}
+	records = append(records, runSessionRecords...)
+
+	// Send the data of all devices to the output sinks, atomically per Kafka sink in transactional mode
+	err = e.sendRecords(records, delivered)
+	if err != nil {
+		sh.kafkaProducerLogger.Error("Failed to send data to output sinks", zap.Int("records", len(records)), zap.Error(err))
+		return fmt.Errorf("failed to send data to output sinks: %w", err)
+	}
+
+	e.applyAlarmTransitions(alarmTransitions)
+	e.applyRunSessionUpdates(runSessionUpdates)
+
+	for _, device := range messageInfo.Devices {
+		messagesPublished.WithLabelValues(messageInfo.Decoder, device.CustomerName).Inc()
+	}
+
+	return nil
+}
+
+// SerializeDataStruct serializes device data as a payload carrying the ID of the input message
+func SerializeDataStruct(id uuid.UUID, data *types.DataStruct) ([]byte, error) {
+	serializedData, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize %s data: %w", data.State, err)
+	}
+
+	p := payload.Payload{
+		ID:               id,
+		Message:          serializedData,
+		MessageTimestamp: data.Timestamp,
+	}
+
+	serializedPayload, err := p.Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize %s payload: %w", data.State, err)
+	}
+
+	return serializedPayload, nil
+}
+
+// shardIndex maps a message key onto one of the shards
+func shardIndex(key string, shards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// runWorker runs the worker on a message, retrying failures as their error policy allows.
+// When the worker fails it returns a nil MessageInfo, and whether the failure was handled.
+func (e *Engine) runWorker(worker *dseworker.Worker, p payload.Payload) (*types.MessageInfo, bool) {
+	for attempt := 1; ; attempt++ {
+		messageInfo, err := worker.RunWorker(p)
+		if err == nil {
+			messagesDecoded.WithLabelValues(messageInfo.Decoder).Inc()
+			return messageInfo, true
+		}
+
+		// Processing failures happen after the payload was decoded
+		var workerErr *workers.WorkerError
+		if errors.As(err, &workerErr) && workerErr.Decoder != "" {
+			messagesDecoded.WithLabelValues(workerErr.Decoder).Inc()
+		}
+
+		retry, handled := e.handleWorkerError(p, err, attempt)
+		if !retry {
+			return nil, handled
+		}
 	}
 }
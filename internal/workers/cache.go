@@ -0,0 +1,198 @@
+package workers
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/johandrevandeventer/devicesdb/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gorm.io/gorm"
+)
+
+// Default cache lifetimes
+const (
+	defaultCacheTTL         = 5 * time.Minute
+	defaultCacheNegativeTTL = 1 * time.Minute
+)
+
+// Metrics
+var (
+	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dse_worker_cache_requests_total",
+		Help: "Total number of device cache lookups",
+	}, []string{"cache", "result"})
+)
+
+// CacheStats holds the hit and miss counts of the device cache
+type CacheStats struct {
+	DeviceHits     uint64 `json:"device_hits"`
+	DeviceMisses   uint64 `json:"device_misses"`
+	CustomerHits   uint64 `json:"customer_hits"`
+	CustomerMisses uint64 `json:"customer_misses"`
+	Devices        int    `json:"devices"`
+	Customers      int    `json:"customers"`
+}
+
+type deviceCacheEntry struct {
+	device    models.Device
+	err       error // Set for negative entries
+	expiresAt time.Time
+}
+
+// deviceCache caches device and customer lookups from the devices database
+type deviceCache struct {
+	mu                 sync.RWMutex
+	ttl                time.Duration
+	negativeTTL        time.Duration
+	devices            map[string]deviceCacheEntry
+	customers          map[string]struct{}
+	customersExpiresAt time.Time
+
+	deviceHits     atomic.Uint64
+	deviceMisses   atomic.Uint64
+	customerHits   atomic.Uint64
+	customerMisses atomic.Uint64
+}
+
+var cache = &deviceCache{
+	ttl:         defaultCacheTTL,
+	negativeTTL: defaultCacheNegativeTTL,
+	devices:     make(map[string]deviceCacheEntry),
+}
+
+// ConfigureCache sets the lifetime of cached devices and customers, and of cached unknown devices
+func ConfigureCache(ttl time.Duration, negativeTTL time.Duration) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.ttl = ttl
+	cache.negativeTTL = negativeTTL
+}
+
+// InvalidateCache removes all cached devices and customers
+func InvalidateCache() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.devices = make(map[string]deviceCacheEntry)
+	cache.customers = nil
+	cache.customersExpiresAt = time.Time{}
+}
+
+// SweepCache removes the expired devices from the cache, so that the identifiers of unknown or retired devices do not
+// accumulate. It returns the number of devices removed.
+func SweepCache() int {
+	now := time.Now()
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	removed := 0
+	for deviceIdentifier, entry := range cache.devices {
+		if !now.Before(entry.expiresAt) {
+			delete(cache.devices, deviceIdentifier)
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// GetCacheStats returns the statistics of the device cache
+func GetCacheStats() CacheStats {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	return CacheStats{
+		DeviceHits:     cache.deviceHits.Load(),
+		DeviceMisses:   cache.deviceMisses.Load(),
+		CustomerHits:   cache.customerHits.Load(),
+		CustomerMisses: cache.customerMisses.Load(),
+		Devices:        len(cache.devices),
+		Customers:      len(cache.customers),
+	}
+}
+
+// GetCachedDevice returns a device by device identifier, loading it from the database when it is not cached.
+// Unknown devices are cached for the negative TTL.
+func GetCachedDevice(deviceIdentifier string) (models.Device, error) {
+	now := time.Now()
+
+	cache.mu.RLock()
+	entry, ok := cache.devices[deviceIdentifier]
+	cache.mu.RUnlock()
+
+	if ok && now.Before(entry.expiresAt) {
+		cache.deviceHits.Add(1)
+		cacheRequests.WithLabelValues("device", "hit").Inc()
+		return entry.device, entry.err
+	}
+
+	cache.deviceMisses.Add(1)
+	cacheRequests.WithLabelValues("device", "miss").Inc()
+
+	device, err := GetDevicesByDeviceIdentifier(deviceIdentifier)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		// Do not cache database failures, nor keep the expired entry
+		if ok {
+			cache.mu.Lock()
+			if cached, found := cache.devices[deviceIdentifier]; found && !now.Before(cached.expiresAt) {
+				delete(cache.devices, deviceIdentifier)
+			}
+			cache.mu.Unlock()
+		}
+		return device, err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if err != nil {
+		cache.devices[deviceIdentifier] = deviceCacheEntry{err: err, expiresAt: now.Add(cache.negativeTTL)}
+	} else {
+		cache.devices[deviceIdentifier] = deviceCacheEntry{device: device, expiresAt: now.Add(cache.ttl)}
+	}
+
+	return device, err
+}
+
+// isCachedCustomer reports whether a customer exists, reloading all customers when the cached set has expired
+func isCachedCustomer(name string) (bool, error) {
+	now := time.Now()
+
+	cache.mu.RLock()
+	customers := cache.customers
+	valid := customers != nil && now.Before(cache.customersExpiresAt)
+	cache.mu.RUnlock()
+
+	if valid {
+		cache.customerHits.Add(1)
+		cacheRequests.WithLabelValues("customer", "hit").Inc()
+		_, ok := customers[name]
+		return ok, nil
+	}
+
+	cache.customerMisses.Add(1)
+	cacheRequests.WithLabelValues("customer", "miss").Inc()
+
+	allCustomers, err := GetAllCustomers()
+	if err != nil {
+		return false, err
+	}
+
+	customers = make(map[string]struct{}, len(allCustomers))
+	for _, c := range allCustomers {
+		customers[c.Name] = struct{}{}
+	}
+
+	cache.mu.Lock()
+	cache.customers = customers
+	cache.customersExpiresAt = now.Add(cache.ttl)
+	cache.mu.Unlock()
+
+	_, ok := customers[name]
+	return ok, nil
+}
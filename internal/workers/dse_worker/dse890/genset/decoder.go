@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"math"
 	"reflect"
+	"slices"
+	"strings"
 
+	"github.com/johandrevandeventer/dse-worker/internal/workers/types"
 	coreutils "github.com/johandrevandeventer/dse-worker/utils"
 )
 
@@ -99,6 +102,86 @@ var ratioMap = map[string]float64{
 	"P005.R117": 1.0,         // FuelTrip
 }
 
+// Sentinel values of registers without a reading, processed as 0
+var outOfRangeValues = []float64{32763, 2147483643, 2147483644, 2147483645, 2147483646, 2147483647, 2147483648}
+
+// field maps an output field to the register it is read from
+type field struct {
+	name     string
+	register string
+}
+
+// Output fields, in the order of the data map.
+// MainFail and Maintanance read P166.R002 and P166.R004, not the registers noted on P166. The mapping is kept so that
+// published data does not change.
+var fields = []field{
+	{"Oil_Pressure", "P004.R000"},
+	{"CoolantTemp", "P004.R001"},
+	{"OilTemp", "P004.R002"},
+	{"Fuel", "P004.R003"},
+	{"AlternatorV", "P004.R004"},
+	{"BatV", "P004.R005"},
+	{"Rpm", "P004.R006"},
+	{"Gen_Freq", "P004.R007"},
+	{"Gen_L1", "P004.R008"},
+	{"Gen_L2", "P004.R010"},
+	{"Gen_L3", "P004.R012"},
+	{"GenTotalP", "P006.R000"},
+	{"GenTotalS", "P006.R008"},
+	{"Loadpercentage", "P006.R022"},
+	{"Avg_Voltage", "P006.R114"},
+	{"Avg_Current", "P006.R130"},
+	{"Next_Service", "P007.R002"},
+	{"RunTime", "P007.R006"},
+	{"GenkWh", "P007.R008"},
+	{"GenkVAh", "P007.R012"},
+	{"TotalStart", "P007.R016"},
+	{"Fuel_Used", "P007.R034"},
+	{"Mode1", "P003.R004"},
+	{"Estop", "P166.R008"},
+	{"MainFail", "P166.R002"},
+	{"ComAlarm", "P166.R000"},
+	{"FailStart", "P166.R002"},
+	{"Maintanance", "P166.R004"},
+	{"AutoMode", "P166.R010"},
+	{"FuelTrip", "P005.R117"},
+}
+
+// P166 status registers, by status name
+var statusRegisters = []field{
+	{"ComAlarm", "P166.R000"},
+	{"FailStart", "P166.R002"},
+	{"MainFail", "P166.R004"},
+	{"Maintanance", "P166.R006"},
+	{"Estop", "P166.R008"},
+	{"AutoMode", "P166.R010"},
+}
+
+// Keys of the registers of DSE890Data in field order (e.g. "P004.R000"), and the index of each key.
+// They are built once rather than for every message.
+var (
+	registerKeys  []string
+	registerIndex = make(map[string]int)
+)
+
+func init() {
+	pmType := reflect.TypeOf(DSE890Data{})
+	for i := 0; i < pmType.NumField(); i++ {
+		pointType := pmType.Field(i).Type
+		for j := 0; j < pointType.NumField(); j++ {
+			key := pmType.Field(i).Name + "." + pointType.Field(j).Name
+			registerIndex[key] = len(registerKeys)
+			registerKeys = append(registerKeys, key)
+		}
+	}
+
+	for _, f := range slices.Concat(fields, statusRegisters) {
+		if _, ok := registerIndex[f.register]; !ok {
+			panic(fmt.Sprintf("field %s reads unknown register %s", f.name, f.register))
+		}
+	}
+}
+
 func Decoder(payload map[string]map[string]any) (rawData, processedData map[string]any, err error) {
 	var dse890Data DSE890Data
 
@@ -119,45 +202,97 @@ func Decoder(payload map[string]map[string]any) (rawData, processedData map[stri
 }
 
 func createDataMap(pm DSE890Data) map[string]any {
-	dataMap := map[string]any{
-		"Oil_Pressure":   pm.P004.R000,
-		"CoolantTemp":    pm.P004.R001,
-		"OilTemp":        pm.P004.R002,
-		"Fuel":           pm.P004.R003,
-		"AlternatorV":    pm.P004.R004,
-		"BatV":           pm.P004.R005,
-		"Rpm":            pm.P004.R006,
-		"Gen_Freq":       pm.P004.R007,
-		"Gen_L1":         pm.P004.R008,
-		"Gen_L2":         pm.P004.R010,
-		"Gen_L3":         pm.P004.R012,
-		"GenTotalP":      pm.P006.R000,
-		"GenTotalS":      pm.P006.R008,
-		"Loadpercentage": pm.P006.R022,
-		"Avg_Voltage":    pm.P006.R114,
-		"Avg_Current":    pm.P006.R130,
-		"Next_Service":   pm.P007.R002,
-		"RunTime":        pm.P007.R006,
-		"GenkWh":         pm.P007.R008,
-		"GenkVAh":        pm.P007.R012,
-		"TotalStart":     pm.P007.R016,
-		"Fuel_Used":      pm.P007.R034,
-		"Mode1":          pm.P003.R004,
-		"Estop":          pm.P166.R008,
-		"MainFail":       pm.P166.R002,
-		"ComAlarm":       pm.P166.R000,
-		"FailStart":      pm.P166.R002,
-		"Maintanance":    pm.P166.R004,
-		"AutoMode":       pm.P166.R010,
-		"FuelTrip":       pm.P005.R117,
+	registers := registerValues(pm)
+
+	dataMap := make(map[string]any, len(fields))
+	for _, f := range fields {
+		dataMap[f.name] = registers[registerIndex[f.register]]
 	}
 
 	return dataMap
 }
 
-func resetOutOfRangeValues(pm *DSE890Data) {
-	outOfRangeValues := []float64{32763, 2147483643, 2147483644, 2147483645, 2147483646, 2147483647, 2147483648}
+// registerValues returns the value of every register, in the order of registerKeys
+func registerValues(pm DSE890Data) []float64 {
+	registers := make([]float64, 0, len(registerKeys))
+
+	pmValue := reflect.ValueOf(pm)
+	for i := 0; i < pmValue.NumField(); i++ {
+		pointValue := pmValue.Field(i)
+
+		for j := 0; j < pointValue.NumField(); j++ {
+			registers = append(registers, pointValue.Field(j).Float())
+		}
+	}
+
+	return registers
+}
+
+// DecodeFields decodes DSE890 genset data field by field, with the register, ratio and sentinel handling of each field
+func DecodeFields(payload map[string]map[string]any) ([]types.DecodedField, error) {
+	var dse890Data DSE890Data
 
+	// Decode map into struct
+	err := coreutils.DecodeMapToStruct(payload, &dse890Data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding DSE890 data: %w", err)
+	}
+
+	rawRegisters := registerValues(dse890Data)
+
+	resetOutOfRangeValues(&dse890Data)
+	applyRatios(&dse890Data)
+
+	processedRegisters := registerValues(dse890Data)
+
+	decodedFields := make([]types.DecodedField, 0, len(fields))
+	for _, f := range fields {
+		point, register, _ := strings.Cut(f.register, ".")
+		_, present := payload[point][register]
+		index := registerIndex[f.register]
+
+		decodedFields = append(decodedFields, types.DecodedField{
+			Name:      f.name,
+			Register:  f.register,
+			Ratio:     ratioMap[f.register],
+			Raw:       rawRegisters[index],
+			Processed: processedRegisters[index],
+			Sentinel:  slices.Contains(outOfRangeValues, rawRegisters[index]),
+			Missing:   !present,
+		})
+	}
+
+	return decodedFields, nil
+}
+
+// Statuses returns whether each P166 status is active, read from the registers noted on P166.
+// Statuses without a reading are left out.
+func Statuses(payload map[string]map[string]any) (map[string]bool, error) {
+	var dse890Data DSE890Data
+
+	// Decode map into struct
+	err := coreutils.DecodeMapToStruct(payload, &dse890Data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding DSE890 data: %w", err)
+	}
+
+	registers := registerValues(dse890Data)
+
+	statuses := make(map[string]bool, len(statusRegisters))
+	for _, f := range statusRegisters {
+		point, register, _ := strings.Cut(f.register, ".")
+		value := registers[registerIndex[f.register]]
+		if _, present := payload[point][register]; !present || slices.Contains(outOfRangeValues, value) {
+			continue
+		}
+
+		statuses[f.name] = value != 0
+	}
+
+	return statuses, nil
+}
+
+func resetOutOfRangeValues(pm *DSE890Data) {
 	pmValue := reflect.ValueOf(pm).Elem()
 
 	for i := 0; i < pmValue.NumField(); i++ {
@@ -182,17 +317,17 @@ func applyRatios(pm *DSE890Data) {
 	pmValue := reflect.ValueOf(pm).Elem()
 
 	// Iterate over the fields in PointMap (P004, P006, etc.)
+	k := 0
 	for i := 0; i < pmValue.NumField(); i++ {
-		point := pmValue.Type().Field(i).Name
 		pointValue := pmValue.Field(i)
 
 		// Iterate over the fields within each struct (R000, R001, etc.)
 		for j := 0; j < pointValue.NumField(); j++ {
-			field := pointValue.Type().Field(j).Name
 			fieldValue := pointValue.Field(j).Float() // Get the integer value of the field
 
-			// Create the key for the ratioMap lookup (e.g., "P004.R000")
-			ratioKey := fmt.Sprintf("%s.%s", point, field)
+			// The key for the ratioMap lookup (e.g., "P004.R000")
+			ratioKey := registerKeys[k]
+			k++
 
 			// Check if there is a ratio for the key
 			if ratio, ok := ratioMap[ratioKey]; ok {
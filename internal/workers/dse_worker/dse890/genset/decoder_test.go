@@ -0,0 +1,71 @@
+package genset
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestStatuses(t *testing.T) {
+	tests := []struct {
+		name string
+		p166 map[string]any
+		want map[string]bool
+	}{
+		{
+			name: "each status reads its own register",
+			p166: map[string]any{"R000": 1.0, "R002": 0.0, "R004": 1.0, "R006": 0.0, "R008": 1.0, "R010": 0.0},
+			want: map[string]bool{"ComAlarm": true, "FailStart": false, "MainFail": true, "Maintanance": false, "Estop": true, "AutoMode": false},
+		},
+		{
+			// The data fields read MainFail from R002 and Maintanance from R004, the statuses must not
+			name: "mains failure is R004 and maintenance is R006",
+			p166: map[string]any{"R002": 1.0, "R004": 0.0, "R006": 1.0},
+			want: map[string]bool{"FailStart": true, "MainFail": false, "Maintanance": true},
+		},
+		{
+			name: "missing registers are left out",
+			p166: map[string]any{"R008": 1.0},
+			want: map[string]bool{"Estop": true},
+		},
+		{
+			name: "sentinel values are left out",
+			p166: map[string]any{"R004": 32763.0, "R008": 2147483647.0, "R010": 1.0},
+			want: map[string]bool{"AutoMode": true},
+		},
+		{
+			name: "no P166",
+			want: map[string]bool{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := map[string]map[string]any{"P004": {"R006": 1500.0}}
+			if tt.p166 != nil {
+				payload["P166"] = tt.p166
+			}
+
+			statuses, err := Statuses(payload)
+			if err != nil {
+				t.Fatalf("Statuses(): %v", err)
+			}
+			if !maps.Equal(statuses, tt.want) {
+				t.Errorf("Statuses() = %v, want %v", statuses, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecoderKeepsStatusFields(t *testing.T) {
+	payload := map[string]map[string]any{"P166": {"R002": 1.0, "R004": 0.0, "R006": 1.0}}
+
+	_, processedData, err := Decoder(payload)
+	if err != nil {
+		t.Fatalf("Decoder(): %v", err)
+	}
+
+	// Published data keeps its mapping of MainFail to R002 and Maintanance to R004
+	if processedData["MainFail"] != 1.0 || processedData["Maintanance"] != 0.0 {
+		t.Errorf("MainFail = %v and Maintanance = %v, want 1 and 0", processedData["MainFail"], processedData["Maintanance"])
+	}
+}
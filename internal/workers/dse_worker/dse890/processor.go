@@ -2,6 +2,7 @@ package dse890
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"slices"
 	"strings"
@@ -11,6 +12,7 @@ import (
 	"github.com/johandrevandeventer/dse-worker/internal/workers/types"
 	"github.com/johandrevandeventer/kafkaclient/payload"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 const (
@@ -37,37 +39,36 @@ func Processor(msg payload.Payload, logger *zap.Logger) (MessageInfo *types.Mess
 
 	logger.Debug("Processing controller", zap.String("controllerID", controllerID))
 
-	ignoredControllers, err := workers.GetIgnoredControllers()
-	if err != nil {
-		return MessageInfo, fmt.Errorf("error getting ignored controllers: %w", err)
-	}
-
-	if slices.Contains(ignoredControllers, controllerID) {
-		return MessageInfo, fmt.Errorf("controller is ignored: %s", controllerID)
-	}
-
 	deviceID = controllerID
 
 	logger.Debug("Processing device", zap.String("deviceID", deviceID))
 
-	ignoredDevices, err := workers.GetIgnoredDevices()
+	// Check the ignore rules that only match identifiers before looking up the device
+	err = workers.CheckIgnored(workers.IgnoreTarget{ControllerID: controllerID, DeviceID: deviceID})
 	if err != nil {
-		return MessageInfo, fmt.Errorf("error getting ignored devices: %w", err)
+		return MessageInfo, err
 	}
 
-	if slices.Contains(ignoredDevices, deviceID) {
-		return MessageInfo, fmt.Errorf("device is ignored: %s", deviceID)
-	}
-
-	device, err := workers.GetDevicesByDeviceIdentifier(deviceID)
+	device, err := workers.GetCachedDevice(deviceID)
 	if err != nil {
-		if strings.Contains(err.Error(), "record not found") {
-			return MessageInfo, fmt.Errorf("device not found: %s", deviceID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return MessageInfo, workers.ErrDeviceNotFound{ID: deviceID}
 		}
 
 		return MessageInfo, fmt.Errorf("error getting device by device ID - %s: %w", deviceID, err)
 	}
 
+	// Check the ignore rules scoped to a customer or site
+	err = workers.CheckIgnored(workers.IgnoreTarget{
+		ControllerID: controllerID,
+		DeviceID:     deviceID,
+		Customer:     device.Site.Customer.Name,
+		Site:         device.Site.Name,
+	})
+	if err != nil {
+		return MessageInfo, err
+	}
+
 	deviceType := device.DeviceType
 	deviceTypeLower := strings.ToLower(deviceType)
 	timestamp := msg.MessageTimestamp
@@ -78,17 +79,22 @@ func Processor(msg payload.Payload, logger *zap.Logger) (MessageInfo *types.Mess
 
 	logger.Debug(fmt.Sprintf("%s :: %s", device.Controller, device.DeviceType))
 
-	switch deviceTypeLower {
-	// Process Genset devices
-	case DeviceTypeGenset:
-		rawData, processedData, err = genset.Decoder(data[controllerID])
-		if err != nil {
-			return MessageInfo, fmt.Errorf("error decoding genset data: %w", err)
-		}
+	rawData, processedData, err = DecodeDevice(deviceTypeLower, data[controllerID])
+	if err != nil {
+		return MessageInfo, err
 	}
 	rawData["SerialNo1"] = device.ControllerIdentifier
 	processedData["SerialNo1"] = device.ControllerIdentifier
 
+	// The P166 statuses are read from their own registers for alarm tracking
+	var statuses map[string]bool
+	if deviceTypeLower == DeviceTypeGenset {
+		statuses, err = genset.Statuses(data[controllerID])
+		if err != nil {
+			return MessageInfo, fmt.Errorf("error decoding genset statuses: %w", err)
+		}
+	}
+
 	deviceStruct := &types.Device{
 		CustomerID:           device.Site.Customer.ID,
 		CustomerName:         device.Site.Customer.Name,
@@ -101,6 +107,7 @@ func Processor(msg payload.Payload, logger *zap.Logger) (MessageInfo *types.Mess
 		DeviceIdentifier:     device.DeviceIdentifier,
 		RawData:              rawData,
 		ProcessedData:        processedData,
+		Statuses:             statuses,
 		Timestamp:            timestamp,
 	}
 
@@ -111,3 +118,64 @@ func Processor(msg payload.Payload, logger *zap.Logger) (MessageInfo *types.Mess
 		Devices:   devices,
 	}, nil
 }
+
+// DecodeDevice decodes the data of a controller as a device type, without looking up the device
+func DecodeDevice(deviceType string, data map[string]map[string]any) (rawData, processedData map[string]any, err error) {
+	switch strings.ToLower(deviceType) {
+	// Process Genset devices
+	case DeviceTypeGenset:
+		rawData, processedData, err = genset.Decoder(data)
+		if err != nil {
+			return rawData, processedData, fmt.Errorf("error decoding genset data: %w", err)
+		}
+
+		return rawData, processedData, nil
+	}
+
+	return rawData, processedData, fmt.Errorf("unsupported device type: %s", deviceType)
+}
+
+// DecodeControllers decodes every controller in a payload as a device type, without looking up the devices.
+// Controllers are returned in order of their identifier, with the decoding of every field.
+func DecodeControllers(deviceType string, payload json.RawMessage) ([]types.DecodedController, error) {
+	var data map[string]map[string]map[string]any
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal payload: %w", err)
+	}
+
+	controllerIDs := make([]string, 0, len(data))
+	for controllerID := range data {
+		controllerIDs = append(controllerIDs, controllerID)
+	}
+	slices.Sort(controllerIDs)
+
+	var controllers []types.DecodedController
+	for _, controllerID := range controllerIDs {
+		rawData, processedData, err := DecodeDevice(deviceType, data[controllerID])
+		if err != nil {
+			return nil, fmt.Errorf("controller %s: %w", controllerID, err)
+		}
+
+		// The controller identifier of the device is not known without a lookup, use the payload key instead
+		rawData["SerialNo1"] = controllerID
+		processedData["SerialNo1"] = controllerID
+
+		var fields []types.DecodedField
+		if strings.ToLower(deviceType) == DeviceTypeGenset {
+			fields, err = genset.DecodeFields(data[controllerID])
+			if err != nil {
+				return nil, fmt.Errorf("controller %s: %w", controllerID, err)
+			}
+		}
+
+		controllers = append(controllers, types.DecodedController{
+			Controller:    controllerID,
+			DeviceType:    deviceType,
+			RawData:       rawData,
+			ProcessedData: processedData,
+			Fields:        fields,
+		})
+	}
+
+	return controllers, nil
+}
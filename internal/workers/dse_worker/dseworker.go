@@ -1,7 +1,10 @@
 package dseworker
 
 import (
+	"encoding/json"
 	"fmt"
+	"slices"
+	"strings"
 
 	"github.com/johandrevandeventer/dse-worker/internal/workers"
 	"github.com/johandrevandeventer/dse-worker/internal/workers/dse_worker/dse890"
@@ -21,13 +24,27 @@ type Worker struct {
 	logger    *zap.Logger
 }
 
+// model is a supported controller model, with its payload decoder, processor and offline decoder
+type model struct {
+	name      string
+	decoder   func(json.RawMessage) (*types.DecodedPayloadInfo, error)
+	processor func(payload.Payload, *zap.Logger) (*types.MessageInfo, error)
+	offline   func(deviceType string, payload json.RawMessage) ([]types.DecodedController, error)
+}
+
+// Supported controller models, in priority order
+var models = []model{
+	{name: "DSE890", decoder: dse890.Decoder, processor: dse890.Processor, offline: dse890.DecodeControllers},
+}
+
 func NewWorker(logger *zap.Logger) *Worker {
 	decoder := NewDecoder()
 	processor := NewProcessor(logger)
 
-	// Priority 1
-	decoder.RegisterDecoder("DSE890", dse890.Decoder)
-	processor.RegisterProcessor("DSE890", dse890.Processor)
+	for _, m := range models {
+		decoder.RegisterDecoder(m.name, m.decoder)
+		processor.RegisterProcessor(m.name, m.processor)
+	}
 
 	return &Worker{
 		decoder:   decoder,
@@ -36,12 +53,8 @@ func NewWorker(logger *zap.Logger) *Worker {
 	}
 }
 
-func (w *Worker) RunWorker(msg []byte) (messageInfo *types.MessageInfo, err error) {
-	p, err := payload.Deserialize(msg)
-	if err != nil {
-		return messageInfo, fmt.Errorf("failed to deserialize data: %w", err)
-	}
-
+// RunWorker decodes and processes a deserialized message
+func (w *Worker) RunWorker(p payload.Payload) (messageInfo *types.MessageInfo, err error) {
 	w.logger.Info("Running worker", zap.String("worker", WorkerTitle), zap.String("topic", p.MqttTopic), zap.String("id", p.ID.String()))
 
 	trimmedTopic := workers.TrimPrefix(p.MqttTopic, DSETopicPrefix)
@@ -49,20 +62,82 @@ func (w *Worker) RunWorker(msg []byte) (messageInfo *types.MessageInfo, err erro
 
 	customer, err := workers.GetValidCustomer(trimmedTopic)
 	if err != nil {
-		return messageInfo, fmt.Errorf("customer validation failed: %w", err)
+		return messageInfo, w.newError(workers.CategoryCustomer, "", fmt.Errorf("customer validation failed: %w", err))
 	}
 
 	decodedPayloadInfo, err := w.decoder.DecodePayload(p.Message)
 	if err != nil {
-		return messageInfo, fmt.Errorf("failed to decode payload: %w", err)
+		return messageInfo, w.newError(workers.CategoryDecode, "", fmt.Errorf("failed to decode payload: %w", err))
 	}
 
 	w.logger.Debug(fmt.Sprintf("%s :: %s", WorkerTitle, customer))
 
-	messageInfo, err = w.processor.ProcessPayload(decodedPayloadInfo.Type, *p)
+	messageInfo, err = w.processor.ProcessPayload(decodedPayloadInfo.Type, p)
 	if err != nil {
-		return messageInfo, fmt.Errorf("failed to process payload: %w", err)
+		return messageInfo, w.newError(workers.CategoryProcess, decodedPayloadInfo.Type, fmt.Errorf("failed to process payload: %w", err))
 	}
 
+	messageInfo.Decoder = decodedPayloadInfo.Type
+
 	return messageInfo, nil
 }
+
+// newError wraps an error with the worker and decoder it originated from
+func (w *Worker) newError(category string, decoder string, err error) error {
+	return &workers.WorkerError{
+		Worker:   WorkerTitle,
+		Decoder:  decoder,
+		Category: category,
+		Err:      err,
+	}
+}
+
+// MessageKey returns the controller identifier of a deserialized message, used to keep the messages of a device in order.
+// It falls back to the MQTT topic when the message cannot be parsed.
+func MessageKey(p payload.Payload) string {
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(p.Message, &data); err != nil || len(data) == 0 {
+		return p.MqttTopic
+	}
+
+	// Use the lowest key so that the result does not depend on map ordering
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+
+	return slices.Min(keys)
+}
+
+// Models returns the names of the supported controller models
+func Models() []string {
+	names := make([]string, len(models))
+	for i, m := range models {
+		names[i] = m.name
+	}
+
+	return names
+}
+
+// DecodeOffline decodes a message with the decoder of a controller model, treating its controllers as devices of a type.
+// The message is either a serialized payload or the payload message itself. No Kafka or devices database is needed.
+func DecodeOffline(modelName string, deviceType string, msg []byte) ([]types.DecodedController, error) {
+	idx := slices.IndexFunc(models, func(m model) bool {
+		return strings.EqualFold(m.name, modelName)
+	})
+	if idx < 0 {
+		return nil, fmt.Errorf("unknown model: %s", modelName)
+	}
+	m := models[idx]
+
+	// Unwrap serialized payloads
+	if p, err := payload.Deserialize(msg); err == nil && len(p.Message) > 0 {
+		msg = p.Message
+	}
+
+	if _, err := m.decoder(msg); err != nil {
+		return nil, fmt.Errorf("failed to decode payload: %w", err)
+	}
+
+	return m.offline(deviceType, msg)
+}
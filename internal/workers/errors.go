@@ -0,0 +1,120 @@
+package workers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/johandrevandeventer/dse-worker/internal/workers/types"
+)
+
+// Error categories
+const (
+	CategoryDeserialize       = "deserialize"
+	CategoryCustomer          = "customer"
+	CategoryCustomerUnknown   = "customer_unknown"
+	CategoryDecode            = "decode"
+	CategoryProcess           = "process"
+	CategoryControllerIgnored = "controller_ignored"
+	CategoryDeviceIgnored     = "device_ignored"
+	CategoryDeviceNotFound    = "device_not_found"
+	CategoryPublish           = "publish"
+)
+
+// WorkerError describes a failure in one of the stages of a worker
+type WorkerError struct {
+	Worker   string
+	Decoder  string
+	Category string
+	Err      error
+}
+
+func (e *WorkerError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *WorkerError) Unwrap() error {
+	return e.Err
+}
+
+// ErrControllerIgnored is returned when a controller is on the ignore list.
+// A zero ID matches any ignored controller with errors.Is.
+type ErrControllerIgnored struct {
+	ID   string
+	Rule *types.IgnoreRule // The matching ignore rule
+}
+
+func (e ErrControllerIgnored) Error() string {
+	return fmt.Sprintf("controller is ignored: %s", e.ID)
+}
+
+func (e ErrControllerIgnored) Is(target error) bool {
+	t, ok := target.(ErrControllerIgnored)
+	return ok && (t.ID == "" || t.ID == e.ID)
+}
+
+// ErrDeviceIgnored is returned when a device is on the ignore list.
+// A zero ID matches any ignored device with errors.Is.
+type ErrDeviceIgnored struct {
+	ID   string
+	Rule *types.IgnoreRule // The matching ignore rule
+}
+
+func (e ErrDeviceIgnored) Error() string {
+	return fmt.Sprintf("device is ignored: %s", e.ID)
+}
+
+func (e ErrDeviceIgnored) Is(target error) bool {
+	t, ok := target.(ErrDeviceIgnored)
+	return ok && (t.ID == "" || t.ID == e.ID)
+}
+
+// ErrDeviceNotFound is returned when a device is not registered in the devices database.
+// A zero ID matches any unknown device with errors.Is.
+type ErrDeviceNotFound struct {
+	ID string
+}
+
+func (e ErrDeviceNotFound) Error() string {
+	return fmt.Sprintf("device not found: %s", e.ID)
+}
+
+func (e ErrDeviceNotFound) Is(target error) bool {
+	t, ok := target.(ErrDeviceNotFound)
+	return ok && (t.ID == "" || t.ID == e.ID)
+}
+
+// ErrCustomerUnknown is returned when the customer in a topic is not registered in the devices database.
+// A zero Name matches any unknown customer with errors.Is.
+type ErrCustomerUnknown struct {
+	Name string
+}
+
+func (e ErrCustomerUnknown) Error() string {
+	return fmt.Sprintf("customer not found: %s", e.Name)
+}
+
+func (e ErrCustomerUnknown) Is(target error) bool {
+	t, ok := target.(ErrCustomerUnknown)
+	return ok && (t.Name == "" || t.Name == e.Name)
+}
+
+// ErrorCategory returns the category of an error returned by a worker
+func ErrorCategory(err error) string {
+	switch {
+	case errors.Is(err, ErrControllerIgnored{}):
+		return CategoryControllerIgnored
+	case errors.Is(err, ErrDeviceIgnored{}):
+		return CategoryDeviceIgnored
+	case errors.Is(err, ErrDeviceNotFound{}):
+		return CategoryDeviceNotFound
+	case errors.Is(err, ErrCustomerUnknown{}):
+		return CategoryCustomerUnknown
+	}
+
+	var workerErr *WorkerError
+	if errors.As(err, &workerErr) && workerErr.Category != "" {
+		return workerErr.Category
+	}
+
+	return CategoryProcess
+}
@@ -0,0 +1,397 @@
+package workers
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/johandrevandeventer/dse-worker/internal/workers/types"
+)
+
+// Default content of a newly created ignore file
+//
+//go:embed ignored/ignored.json
+var defaultIgnoredFile []byte
+
+// IgnoreTarget identifies a message checked against the ignore rules.
+// Rules scoped to a customer or site only match once those are known.
+type IgnoreTarget struct {
+	ControllerID string
+	DeviceID     string
+	Customer     string
+	Site         string
+}
+
+// compiledRule is an ignore rule with its patterns compiled. A nil matcher matches anything.
+type compiledRule struct {
+	rule       types.IgnoreRule
+	controller func(string) bool
+	device     func(string) bool
+	customer   func(string) bool
+	site       func(string) bool
+}
+
+// ignoreSet is a loaded ignore file and its compiled rules
+type ignoreSet struct {
+	file  types.IgnoredControllersAndDevices
+	rules []compiledRule
+}
+
+// ignoreList holds the last successfully loaded ignore file
+type ignoreList struct {
+	current atomic.Pointer[ignoreSet]
+
+	mu       sync.Mutex // Serializes reloads
+	filePath string
+	modTime  time.Time
+	size     int64
+}
+
+var ignored = &ignoreList{}
+
+// LoadIgnoredFile loads the ignore list from a file, creating the file with default content when it does not exist
+func LoadIgnoredFile(filePath string) error {
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(filePath), 0o770); err != nil {
+			return fmt.Errorf("error creating ignore file directory: %w", err)
+		}
+
+		if err := os.WriteFile(filePath, defaultIgnoredFile, 0o644); err != nil {
+			return fmt.Errorf("error creating ignore file: %w", err)
+		}
+	}
+
+	ignored.mu.Lock()
+	defer ignored.mu.Unlock()
+
+	ignored.filePath = filePath
+	return ignored.load()
+}
+
+// ReloadIgnoredFile reloads the ignore list when the file changed since it was last loaded.
+// A malformed file keeps the last good list in place.
+func ReloadIgnoredFile(force bool) (reloaded bool, err error) {
+	ignored.mu.Lock()
+	defer ignored.mu.Unlock()
+
+	if ignored.filePath == "" {
+		return false, fmt.Errorf("ignore file not loaded")
+	}
+
+	info, err := os.Stat(ignored.filePath)
+	if err != nil {
+		return false, fmt.Errorf("error reading ignore file: %w", err)
+	}
+
+	if !force && info.ModTime().Equal(ignored.modTime) && info.Size() == ignored.size {
+		return false, nil
+	}
+
+	if err := ignored.load(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// IgnoredFilePath returns the path of the loaded ignore file
+func IgnoredFilePath() string {
+	ignored.mu.Lock()
+	defer ignored.mu.Unlock()
+
+	return ignored.filePath
+}
+
+// load reads the ignore file and swaps it in. Callers must hold the mutex.
+func (l *ignoreList) load() error {
+	info, err := os.Stat(l.filePath)
+	if err != nil {
+		return fmt.Errorf("error reading ignore file: %w", err)
+	}
+
+	data, err := os.ReadFile(l.filePath)
+	if err != nil {
+		return fmt.Errorf("error reading ignore file: %w", err)
+	}
+
+	// Remember the file state even if it is malformed, so that it is not reparsed until it changes again
+	l.modTime = info.ModTime()
+	l.size = info.Size()
+
+	var ignoredControllersAndDevices types.IgnoredControllersAndDevices
+	if err := json.Unmarshal(data, &ignoredControllersAndDevices); err != nil {
+		return fmt.Errorf("error decoding JSON: %w", err)
+	}
+
+	set, err := compileIgnoreSet(ignoredControllersAndDevices)
+	if err != nil {
+		return err
+	}
+
+	l.current.Store(set)
+	return nil
+}
+
+// compileIgnoreSet compiles the rules of an ignore file. The flat lists become exact-match rules.
+func compileIgnoreSet(file types.IgnoredControllersAndDevices) (*ignoreSet, error) {
+	rules := make([]types.IgnoreRule, 0, len(file.IgnoredControllers)+len(file.IgnoredDevices)+len(file.Rules))
+	for _, controllerID := range file.IgnoredControllers {
+		rules = append(rules, types.IgnoreRule{Controller: controllerID, Reason: "listed in ignored_controllers"})
+	}
+	for _, deviceID := range file.IgnoredDevices {
+		rules = append(rules, types.IgnoreRule{Device: deviceID, Reason: "listed in ignored_devices"})
+	}
+	rules = append(rules, file.Rules...)
+
+	set := &ignoreSet{file: file}
+	for i, rule := range rules {
+		if rule.Controller == "" && rule.Device == "" && rule.Customer == "" && rule.Site == "" {
+			return nil, fmt.Errorf("ignore rule %d matches everything", i)
+		}
+
+		compiled := compiledRule{rule: rule}
+		var err error
+		if compiled.controller, err = compilePattern(rule.Controller); err != nil {
+			return nil, fmt.Errorf("ignore rule %d: invalid controller pattern: %w", i, err)
+		}
+		if compiled.device, err = compilePattern(rule.Device); err != nil {
+			return nil, fmt.Errorf("ignore rule %d: invalid device pattern: %w", i, err)
+		}
+		if compiled.customer, err = compilePattern(rule.Customer); err != nil {
+			return nil, fmt.Errorf("ignore rule %d: invalid customer pattern: %w", i, err)
+		}
+		if compiled.site, err = compilePattern(rule.Site); err != nil {
+			return nil, fmt.Errorf("ignore rule %d: invalid site pattern: %w", i, err)
+		}
+
+		set.rules = append(set.rules, compiled)
+	}
+
+	return set, nil
+}
+
+// compilePattern compiles an exact, glob or "re:" prefixed regular expression pattern
+func compilePattern(pattern string) (func(string) bool, error) {
+	switch {
+	case pattern == "":
+		return nil, nil
+	case strings.HasPrefix(pattern, "re:"):
+		re, err := regexp.Compile(strings.TrimPrefix(pattern, "re:"))
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	case strings.ContainsAny(pattern, "*?["):
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+		return func(s string) bool {
+			ok, _ := path.Match(pattern, s)
+			return ok
+		}, nil
+	default:
+		return func(s string) bool {
+			return s == pattern
+		}, nil
+	}
+}
+
+// matches reports whether a rule applies to a target at the given time
+func (r compiledRule) matches(target IgnoreTarget, now time.Time) bool {
+	if r.rule.ExpiresAt != nil && !now.Before(*r.rule.ExpiresAt) {
+		return false
+	}
+
+	return matchField(r.controller, target.ControllerID) &&
+		matchField(r.device, target.DeviceID) &&
+		matchField(r.customer, target.Customer) &&
+		matchField(r.site, target.Site)
+}
+
+// matchField matches a value against a compiled pattern. Unknown values only match unset patterns.
+func matchField(match func(string) bool, value string) bool {
+	if match == nil {
+		return true
+	}
+
+	return value != "" && match(value)
+}
+
+// getIgnored returns the current ignore set
+func getIgnored() (*ignoreSet, error) {
+	set := ignored.current.Load()
+	if set == nil {
+		return nil, fmt.Errorf("ignore list not loaded")
+	}
+
+	return set, nil
+}
+
+// CheckIgnored returns ErrDeviceIgnored or ErrControllerIgnored with the matching rule when a target is ignored
+func CheckIgnored(target IgnoreTarget) error {
+	set, err := getIgnored()
+	if err != nil {
+		return fmt.Errorf("error checking ignore rules: %w", err)
+	}
+
+	now := time.Now()
+	for _, r := range set.rules {
+		if !r.matches(target, now) {
+			continue
+		}
+
+		rule := r.rule
+		if rule.Device != "" {
+			return ErrDeviceIgnored{ID: target.DeviceID, Rule: &rule}
+		}
+
+		return ErrControllerIgnored{ID: target.ControllerID, Rule: &rule}
+	}
+
+	return nil
+}
+
+// GetIgnoredFile returns the content of the loaded ignore file
+func GetIgnoredFile() (types.IgnoredControllersAndDevices, error) {
+	set, err := getIgnored()
+	if err != nil {
+		return types.IgnoredControllersAndDevices{}, err
+	}
+
+	return set.file, nil
+}
+
+// AddIgnoredController adds a rule ignoring a controller to the ignore file and reloads it.
+// If the controller already has a rule of its own, that rule gets the new reason and expiry instead. A controller in
+// ignored_controllers is left there without a reason or expiry, and moved to a rule otherwise.
+func AddIgnoredController(controllerID string, reason string, expiresAt *time.Time) error {
+	if controllerID == "" {
+		return fmt.Errorf("controller ID is required")
+	}
+
+	return updateIgnoredFile(func(file *types.IgnoredControllersAndDevices) bool {
+		listed := slices.Contains(file.IgnoredControllers, controllerID)
+		if listed && reason == "" && expiresAt == nil {
+			return false
+		}
+
+		if listed {
+			file.IgnoredControllers = slices.DeleteFunc(file.IgnoredControllers, func(id string) bool {
+				return id == controllerID
+			})
+		}
+
+		updated := false
+		rules := file.Rules[:0]
+		for _, rule := range file.Rules {
+			if controllerRule(rule, controllerID) {
+				// Duplicates left by earlier adds are dropped
+				if updated {
+					continue
+				}
+
+				rule.Reason = reason
+				rule.ExpiresAt = expiresAt
+				updated = true
+			}
+			rules = append(rules, rule)
+		}
+		file.Rules = rules
+
+		if !updated {
+			file.Rules = append(file.Rules, types.IgnoreRule{Controller: controllerID, Reason: reason, ExpiresAt: expiresAt})
+		}
+
+		return true
+	})
+}
+
+// controllerRule reports whether a rule only matches a controller
+func controllerRule(rule types.IgnoreRule, controllerID string) bool {
+	return rule.Controller == controllerID && rule.Device == "" && rule.Customer == "" && rule.Site == ""
+}
+
+// RemoveIgnoredController removes a controller from ignored_controllers, and the rules that only match that controller,
+// from the ignore file and reloads it. It returns false if the controller was not ignored by either.
+func RemoveIgnoredController(controllerID string) (removed bool, err error) {
+	err = updateIgnoredFile(func(file *types.IgnoredControllersAndDevices) bool {
+		controllers := file.IgnoredControllers[:0]
+		for _, id := range file.IgnoredControllers {
+			if id == controllerID {
+				removed = true
+				continue
+			}
+			controllers = append(controllers, id)
+		}
+		file.IgnoredControllers = controllers
+
+		rules := file.Rules[:0]
+		for _, rule := range file.Rules {
+			if controllerRule(rule, controllerID) {
+				removed = true
+				continue
+			}
+			rules = append(rules, rule)
+		}
+		file.Rules = rules
+
+		return removed
+	})
+
+	return removed, err
+}
+
+// updateIgnoredFile applies a change to the ignore file and reloads it.
+// The file is read again rather than taken from the loaded list, so that changes made by hand are kept.
+func updateIgnoredFile(update func(file *types.IgnoredControllersAndDevices) bool) error {
+	ignored.mu.Lock()
+	defer ignored.mu.Unlock()
+
+	if ignored.filePath == "" {
+		return fmt.Errorf("ignore file not loaded")
+	}
+
+	data, err := os.ReadFile(ignored.filePath)
+	if err != nil {
+		return fmt.Errorf("error reading ignore file: %w", err)
+	}
+
+	var file types.IgnoredControllersAndDevices
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("error decoding JSON: %w", err)
+	}
+
+	if !update(&file) {
+		return nil
+	}
+
+	// Validate the change before writing it
+	if _, err := compileIgnoreSet(file); err != nil {
+		return err
+	}
+
+	data, err = json.MarshalIndent(file, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error encoding JSON: %w", err)
+	}
+
+	tmpFilePath := ignored.filePath + ".tmp"
+	if err := os.WriteFile(tmpFilePath, data, 0o644); err != nil {
+		return fmt.Errorf("error writing ignore file: %w", err)
+	}
+
+	if err := os.Rename(tmpFilePath, ignored.filePath); err != nil {
+		return fmt.Errorf("error writing ignore file: %w", err)
+	}
+
+	return ignored.load()
+}
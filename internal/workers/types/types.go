@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/johandrevandeventer/kafkaclient/payload"
 )
 
 type DecodedPayloadInfo struct {
@@ -12,10 +13,28 @@ type DecodedPayloadInfo struct {
 }
 
 type IgnoredControllersAndDevices struct {
-	IgnoredControllers []string `json:"ignored_controllers"`
-	IgnoredDevices     []string `json:"ignored_devices"`
+	IgnoredControllers []string     `json:"ignored_controllers"`
+	IgnoredDevices     []string     `json:"ignored_devices"`
+	Rules              []IgnoreRule `json:"rules,omitempty"`
 }
 
+// Ignore rule, matching when all of its set fields match.
+// Fields match exactly, as a glob when they contain *, ? or [, or as a regular expression when prefixed with "re:".
+type IgnoreRule struct {
+	Controller string     `json:"controller,omitempty"`
+	Device     string     `json:"device,omitempty"`
+	Customer   string     `json:"customer,omitempty"`
+	Site       string     `json:"site,omitempty"`
+	Reason     string     `json:"reason,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // The rule no longer applies after this time
+}
+
+// Data states
+const (
+	StatePre  = "Pre"  // Raw data
+	StatePost = "Post" // Processed data
+)
+
 type DataStruct struct {
 	State                string
 	CustomerID           uuid.UUID
@@ -31,9 +50,41 @@ type DataStruct struct {
 	Timestamp            time.Time
 }
 
+// Decoded field of a device, with the register it is read from and how its value was converted
+type DecodedField struct {
+	Name      string  `json:"name"`
+	Register  string  `json:"register"`
+	Ratio     float64 `json:"ratio"`
+	Raw       float64 `json:"raw"`
+	Processed float64 `json:"processed"`
+	Sentinel  bool    `json:"sentinel,omitempty"` // The raw value is a no-reading sentinel, processed as 0
+	Missing   bool    `json:"missing,omitempty"`  // The register is not in the payload
+}
+
+// Decoded data of a controller, decoded without a device lookup
+type DecodedController struct {
+	Controller    string         `json:"controller"`
+	DeviceType    string         `json:"device_type"`
+	RawData       map[string]any `json:"raw_data"`
+	ProcessedData map[string]any `json:"processed_data"`
+	Fields        []DecodedField `json:"fields"`
+}
+
+// Dead-letter envelope for messages the worker could not process
+type DeadLetter struct {
+	Payload   payload.Payload `json:"payload"`
+	Category  string          `json:"category"`
+	Error     string          `json:"error"`
+	Worker    string          `json:"worker"`
+	Decoder   string          `json:"decoder,omitempty"`
+	Attempts  int             `json:"attempts"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
 // Base message structure
 type MessageInfo struct {
 	MessageID string `json:"message_id"` // Unique identifier for the message
+	Decoder   string `json:"decoder"`    // Name of the decoder that recognised the message
 
 	// Optional controller information
 	Controller *Controller `json:"controller,omitempty"`
@@ -52,6 +103,80 @@ type Controller struct {
 	LastSeen time.Time         `json:"last_seen,omitempty"`
 }
 
+// Controller connectivity events
+const (
+	EventControllerOffline = "controller_offline"
+	EventControllerOnline  = "controller_online"
+)
+
+// Event of a controller going quiet past its offline threshold, or sending messages again
+type ControllerEvent struct {
+	Event            string     `json:"event"`
+	Controller       Controller `json:"controller"`
+	DeviceType       string     `json:"device_type"`
+	CustomerID       uuid.UUID  `json:"customer_id"`
+	CustomerName     string     `json:"customer_name"`
+	SiteID           uuid.UUID  `json:"site_id"`
+	SiteName         string     `json:"site_name"`
+	ThresholdSeconds int        `json:"threshold_seconds"`
+	OfflineSince     time.Time  `json:"offline_since"`             // Last message before the controller went quiet
+	OfflineSeconds   float64    `json:"offline_seconds,omitempty"` // How long the controller was offline, set when back online
+	Timestamp        time.Time  `json:"timestamp"`
+}
+
+// Alarm events
+const (
+	EventAlarmRaised  = "alarm_raised"
+	EventAlarmCleared = "alarm_cleared"
+)
+
+// Event of a device alarm being raised or cleared
+type AlarmEvent struct {
+	Event                string     `json:"event"`
+	Alarm                string     `json:"alarm"`
+	Severity             string     `json:"severity"`
+	CustomerID           uuid.UUID  `json:"customer_id"`
+	CustomerName         string     `json:"customer_name"`
+	SiteID               uuid.UUID  `json:"site_id"`
+	SiteName             string     `json:"site_name"`
+	Controller           string     `json:"controller"`
+	DeviceType           string     `json:"device_type"`
+	ControllerIdentifier string     `json:"controller_identifier"`
+	DeviceName           string     `json:"device_name"`
+	DeviceIdentifier     string     `json:"device_identifier"`
+	RaisedAt             time.Time  `json:"raised_at"`
+	ClearedAt            *time.Time `json:"cleared_at,omitempty"`
+	DurationSeconds      float64    `json:"duration_seconds,omitempty"` // How long the alarm was active, set when cleared
+	Timestamp            time.Time  `json:"timestamp"`
+}
+
+// Run session triggers
+const (
+	RunTriggerMainsFail = "mains_fail"
+	RunTriggerManual    = "manual"
+)
+
+// Run session of a genset, from start to stop
+type RunSession struct {
+	CustomerID           uuid.UUID `json:"customer_id"`
+	CustomerName         string    `json:"customer_name"`
+	SiteID               uuid.UUID `json:"site_id"`
+	SiteName             string    `json:"site_name"`
+	Controller           string    `json:"controller"`
+	DeviceType           string    `json:"device_type"`
+	ControllerIdentifier string    `json:"controller_identifier"`
+	DeviceName           string    `json:"device_name"`
+	DeviceIdentifier     string    `json:"device_identifier"`
+	Start                time.Time `json:"start"`
+	End                  time.Time `json:"end"`
+	DurationSeconds      float64   `json:"duration_seconds"`
+	RunHours             *float64  `json:"run_hours,omitempty"`  // Delta of the RunTime counter
+	EnergyKWh            *float64  `json:"energy_kwh,omitempty"` // Delta of GenkWh
+	FuelUsed             *float64  `json:"fuel_used,omitempty"`  // Delta of Fuel_Used
+	PeakLoadPercentage   float64   `json:"peak_load_percentage"`
+	Trigger              string    `json:"trigger,omitempty"` // mains_fail or manual, empty when unknown
+}
+
 // Device information
 type Device struct {
 	CustomerID           uuid.UUID
@@ -65,5 +190,29 @@ type Device struct {
 	DeviceIdentifier     string
 	RawData              map[string]any
 	ProcessedData        map[string]any
+	Statuses             map[string]bool // Status flags by name, e.g. the P166 alarms of a genset
 	Timestamp            time.Time
 }
+
+// DataStruct returns the data of a device in a state, Pre for the raw data or Post for the processed data
+func (d Device) DataStruct(state string) *DataStruct {
+	data := d.ProcessedData
+	if state == StatePre {
+		data = d.RawData
+	}
+
+	return &DataStruct{
+		State:                state,
+		CustomerID:           d.CustomerID,
+		CustomerName:         d.CustomerName,
+		SiteID:               d.SiteID,
+		SiteName:             d.SiteName,
+		Controller:           d.Controller,
+		DeviceType:           d.DeviceType,
+		ControllerIdentifier: d.ControllerIdentifier,
+		DeviceName:           d.DeviceName,
+		DeviceIdentifier:     d.DeviceIdentifier,
+		Data:                 data,
+		Timestamp:            d.Timestamp,
+	}
+}
@@ -3,7 +3,6 @@ package workers
 import (
 	"encoding/json"
 	"fmt"
-	"os"
 	"strings"
 	"time"
 
@@ -126,58 +125,36 @@ func GetValidCustomer(topic string) (string, error) {
 		return "", fmt.Errorf("failed to get customer: %w", err)
 	}
 
-	customers, err := GetAllCustomers()
+	exists, err := isCachedCustomer(customer)
 	if err != nil {
 		return "", fmt.Errorf("failed to get customers: %w", err)
 	}
 
-	for _, c := range customers {
-		if c.Name == customer {
-			return customer, nil
-		}
+	if exists {
+		return customer, nil
 	}
 
-	return "", fmt.Errorf("customer not found: %s", customer)
+	return "", ErrCustomerUnknown{Name: customer}
 }
 
-// Helper function to read ignored controllers from json file
-func readIgnoredFile() (*types.IgnoredControllersAndDevices, error) {
-	// Open the JSON file
-	file, err := os.Open("./internal/workers/ignored/ignored.json")
-	if err != nil {
-		return nil, fmt.Errorf("error opening file: %w", err)
-	}
-	defer file.Close()
-
-	// Decode the JSON file into the Config struct
-	var ignoredControllersAndDevices types.IgnoredControllersAndDevices
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&ignoredControllersAndDevices)
-	if err != nil {
-		return nil, fmt.Errorf("error decoding JSON: %w", err)
-	}
-
-	return &ignoredControllersAndDevices, nil
-}
-
-// Helper function to read and return ignored controllers from json file
+// Helper function to return the ignored controllers
 func GetIgnoredControllers() ([]string, error) {
-	ignoredControllersAndDevices, err := readIgnoredFile()
+	ignoredControllersAndDevices, err := getIgnored()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read ignored controllers: %w", err)
 	}
 
-	return ignoredControllersAndDevices.IgnoredControllers, nil
+	return ignoredControllersAndDevices.file.IgnoredControllers, nil
 }
 
-// Helper function to read and return ignored devices from json file
+// Helper function to return the ignored devices
 func GetIgnoredDevices() ([]string, error) {
-	ignoredControllersAndDevices, err := readIgnoredFile()
+	ignoredControllersAndDevices, err := getIgnored()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read ignored devices: %w", err)
 	}
 
-	return ignoredControllersAndDevices.IgnoredDevices, nil
+	return ignoredControllersAndDevices.file.IgnoredDevices, nil
 }
 
 // Helper function to check if a DataStruct is empty
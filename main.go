@@ -42,11 +42,24 @@ func main() {
 
 	// Initialize the logger
 	coreutils.VerbosePrintln(textutils.ColorText(textutils.Green, "Initializing Logger..."))
-	cfg := config.GetConfig()
+	cfg, err := config.GetConfig()
+	if err != nil {
+		fmt.Println(textutils.ColorText(textutils.Red, err.Error()))
+		return
+	}
 	initializers.InitLogger(cfg)
 	logger := logging.GetLogger("main")
 	coreutils.VerbosePrintln(textutils.ColorText(textutils.Cyan, "-> Logger initialized"))
 
+	// Initialize the database settings
+	coreutils.VerbosePrintln(textutils.ColorText(textutils.Green, "Initializing database settings..."))
+	err = initializers.InitDatabase(cfg)
+	if err != nil {
+		fmt.Println(textutils.ColorText(textutils.Red, err.Error()))
+		return
+	}
+	coreutils.VerbosePrintln(textutils.ColorText(textutils.Cyan, "-> Database settings initialized"))
+
 	// Initialize the state persistence
 	coreutils.VerbosePrintln(textutils.ColorText(textutils.Green, "Initializing state persistence..."))
 	statePersister, err := initializers.InitPersist(cfg)